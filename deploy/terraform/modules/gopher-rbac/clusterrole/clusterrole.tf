@@ -6,6 +6,24 @@ resource "kubernetes_cluster_role" "clusterrole" {
   rule {
     api_groups = [""]
     resources  = ["secrets"]
-    verbs      = ["get", "create", "update"]
+    verbs      = ["get", "list", "create", "update"]
+  }
+
+  rule {
+    api_groups = [""]
+    resources  = ["namespaces"]
+    verbs      = ["list"]
+  }
+
+  rule {
+    api_groups = ["events.k8s.io"]
+    resources  = ["events"]
+    verbs      = ["create"]
+  }
+
+  rule {
+    api_groups = ["apps"]
+    resources  = ["deployments", "statefulsets", "daemonsets"]
+    verbs      = ["patch"]
   }
 }
@@ -0,0 +1,13 @@
+variable "namespace" {}
+
+variable "cluster_role_name" {}
+
+variable "labels" {
+  type    = "map"
+  default = {}
+}
+
+variable "annotations" {
+  type    = "map"
+  default = {}
+}
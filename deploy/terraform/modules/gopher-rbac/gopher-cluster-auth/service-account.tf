@@ -0,0 +1,31 @@
+# Service Account
+# Single identity that writes secrets into many namespaces, guard it with ALLOWED_NAMESPACES in the job
+resource "kubernetes_service_account" "serviceaccount" {
+  metadata {
+    labels      = "${var.labels}"
+    annotations = "${var.annotations}"
+    name        = "${var.namespace}-${var.cluster_role_name}-cluster"
+    namespace   = "${var.namespace}"
+  }
+
+  automount_service_account_token = true
+}
+
+# ClusterRole Binding
+resource "kubernetes_cluster_role_binding" "clusterrolebinding" {
+  metadata {
+    name = "${var.namespace}-${var.cluster_role_name}-cluster"
+  }
+
+  role_ref {
+    api_group = "rbac.authorization.k8s.io"
+    kind      = "ClusterRole"
+    name      = "${var.cluster_role_name}"
+  }
+
+  subject {
+    kind      = "ServiceAccount"
+    name      = "${var.namespace}-${var.cluster_role_name}-cluster"
+    namespace = "${var.namespace}"
+  }
+}
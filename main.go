@@ -1,16 +1,232 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
 	handler "github.com/trx35479/vault-gopher/secret-injector"
+	"github.com/trx35479/vault-gopher/secret-injector/apis"
 	"github.com/trx35479/vault-gopher/secret-injector/log"
 )
 
+var logger = log.NewLogger()
+
+// Version of the build, set with go build -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+const usage = `usage: vault-gopher [command] [flags]
+
+Commands:
+  sync       sync the secrets once and exit, the default when no command is given
+  daemon     keep running and sync the secrets after each --interval
+  diff       print how the secrets in kubernetes differ from vault, exit code 3 when they do
+  validate   check SECRET_OBJECT and the permissions of the vault token
+  import     copy kubernetes secrets into vault and print the SECRET_OBJECT that syncs them back
+  rotate     give new values to the keys of a secret, write them to vault and sync the secret
+  version    print the version
+
+Every env variable of the app has a flag, a flag takes precedence over the env which takes precedence
+over the default. Run vault-gopher <command> --help for the flags of a command.
+`
+
 func main() {
-	var logger = log.NewLogger()
-	logger.Println("App starting")
-	err := handler.CreateObject("secret")
+	apis.UserAgent = "vault-gopher/" + version
+
+	command, args := parseCommand(os.Args[1:])
+	ctx := signalContext()
+	switch command {
+	case "sync":
+		syncCommand(ctx, args, false)
+	case "daemon":
+		syncCommand(ctx, args, true)
+	case "diff":
+		diffCommand(ctx, args)
+	case "validate":
+		validateCommand(ctx, args)
+	case "import":
+		importCommand(ctx, args)
+	case "rotate":
+		rotateCommand(ctx, args)
+	case "version":
+		fmt.Printf("vault-gopher %s\n", version)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// Return the command of the arguments and the arguments left for its flags
+// Without a command the flags are the ones of sync, as they were before there were commands
+func parseCommand(args []string) (string, []string) {
+	// --help alone is the usage of the app rather than the one of sync
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		return "help", nil
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	return "sync", args
+}
+
+// Return a context that is cancelled on SIGINT or SIGTERM, so the command stops the calls it is making
+// and revokes its token before the pod goes away. A second signal kills the app right away
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-signals
+		logger.Printf("Received %s, stopping", s)
+		cancel()
+		signal.Stop(signals)
+	}()
+	return ctx
+}
+
+// Return a flag set with the flags of every env variable, the usage shows the arguments of the command
+func newFlagSet(command, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: vault-gopher %s [flags]%s\n\nFlags:\n", command, arguments)
+		fs.PrintDefaults()
+	}
+	handler.Flags(fs)
+	return fs
+}
+
+// Tell if a flag was given on the command line
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Sync the secrets once, or after each interval in the daemon
+func syncCommand(ctx context.Context, args []string, daemon bool) {
+	command := "sync"
+	if daemon {
+		command = "daemon"
+	}
+	fs := newFlagSet(command, "")
+	dryRun := handler.DryRunFlag(fs)
+	fs.Parse(args)
+
+	if daemon && handler.SyncInterval <= 0 {
+		logger.Fatal("daemon needs an --interval greater than 0 (env SYNC_INTERVAL)")
+	}
+	if *dryRun {
+		diff(ctx)
+		return
+	}
+
+	logger.Printf("App %s starting", version)
+	if handler.SyncInterval > 0 {
+		trackVersions := handler.WatchTrackVersions(isSet(fs, "track-versions"))
+		// The daemon only returns without an error once it's told to stop
+		if err := handler.Watch(ctx, handler.ObjectName, handler.SyncInterval, trackVersions); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Println("App stopped")
+		return
+	}
+	err := handler.CreateObject(ctx, handler.ObjectName)
 	if err != nil {
+		// Exit with a different code when only some of the secrets failed
+		// so the job status tells a failed login apart from a bad vault path
+		var syncErr *handler.SyncError
+		if errors.As(err, &syncErr) {
+			logger.Error(err)
+			os.Exit(2)
+		}
 		logger.Fatal(err)
 	}
 	logger.Println("Secret has been created")
 }
+
+// Copy existing kubernetes secrets into vault, the SECRET_OBJECT that syncs them back is printed on stdout
+func importCommand(ctx context.Context, args []string) {
+	fs := newFlagSet("import", "")
+	var opts handler.ImportOptions
+	names := fs.String("name", "", "comma separated names of the secrets to import")
+	fs.StringVar(&opts.Selector, "selector", "", "label selector of the secrets to import, ex. app=billing")
+	fs.StringVar(&opts.Path, "path", handler.DefaultImportPath,
+		"template of the kv path under VAULT_SECRET_PATH each secret is written to, given .Namespace and .Name")
+	fs.StringVar(&opts.Existing, "existing", handler.ImportSkip,
+		"what to do with keys already in vault with another value, skip keeps them and diff fails the secret")
+	fs.Parse(args)
+
+	opts.Names = handler.SplitList(*names)
+	if err := handler.Import(ctx, handler.ObjectName, opts, os.Stdout); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// Give new values to the keys of a secret of SECRET_OBJECT, write them to vault and sync the secret
+func rotateCommand(ctx context.Context, args []string) {
+	fs := newFlagSet("rotate", " <secret>")
+	var opts handler.RotateOptions
+	keys := fs.String("key", "", "comma separated keys to rotate, every key with a generator by default")
+	restart := fs.String("restart", "", "comma separated workloads to restart once synced, ex. deployment/billing")
+	fs.DurationVar(&opts.Grace, "grace", 24*time.Hour,
+		"how long the value from before the rotation is kept under <key>_previous, 0 drops it right away")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	opts.Secret = fs.Arg(0)
+	opts.Keys = handler.SplitList(*keys)
+	opts.Restart = handler.SplitList(*restart)
+	if err := handler.Rotate(ctx, handler.ObjectName, opts); err != nil {
+		logger.Fatal(err)
+	}
+	logger.Printf("Secret %s has been rotated", opts.Secret)
+}
+
+// Check SECRET_OBJECT and the permissions of the vault token without writing anything
+func validateCommand(ctx context.Context, args []string) {
+	fs := newFlagSet("validate", "")
+	fs.Parse(args)
+
+	if err := handler.Validate(ctx, os.Stdout); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// Print how the secrets in kubernetes differ from vault, the exit code is 3 when they do
+func diffCommand(ctx context.Context, args []string) {
+	fs := newFlagSet("diff", "")
+	fs.Parse(args)
+	diff(ctx)
+}
+
+func diff(ctx context.Context) {
+	err := handler.Diff(ctx, handler.ObjectName, os.Stdout)
+	var driftErr *handler.DriftError
+	var syncErr *handler.SyncError
+	switch {
+	case err == nil:
+	case errors.As(err, &driftErr):
+		logger.Warn(err)
+		os.Exit(3)
+	case errors.As(err, &syncErr):
+		logger.Error(err)
+		os.Exit(2)
+	default:
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantCommand string
+		wantArgs    []string
+	}{
+		{name: "none", wantCommand: "sync"},
+		{name: "sync", args: []string{"sync"}, wantCommand: "sync", wantArgs: []string{}},
+		{name: "daemon", args: []string{"daemon"}, wantCommand: "daemon", wantArgs: []string{}},
+		{name: "diff", args: []string{"diff"}, wantCommand: "diff", wantArgs: []string{}},
+		{name: "validate", args: []string{"validate"}, wantCommand: "validate", wantArgs: []string{}},
+		{name: "import", args: []string{"import"}, wantCommand: "import", wantArgs: []string{}},
+		{name: "rotate", args: []string{"rotate"}, wantCommand: "rotate", wantArgs: []string{}},
+		{name: "version", args: []string{"version"}, wantCommand: "version", wantArgs: []string{}},
+		{name: "help", args: []string{"--help"}, wantCommand: "help"},
+		{name: "help-short", args: []string{"-h"}, wantCommand: "help"},
+		{name: "command-help", args: []string{"sync", "--help"}, wantCommand: "sync", wantArgs: []string{"--help"}},
+		{name: "flags", args: []string{"--namespace", "sit-sre"}, wantCommand: "sync", wantArgs: []string{"--namespace", "sit-sre"}},
+		{name: "command-flags", args: []string{"rotate", "--key", "password", "app-sit-secret"}, wantCommand: "rotate",
+			wantArgs: []string{"--key", "password", "app-sit-secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, args := parseCommand(tt.args)
+			if command != tt.wantCommand {
+				t.Errorf("parseCommand() command = %v, want %v", command, tt.wantCommand)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("parseCommand() args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package apis
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ConsistencyNone sends the requests as they are, a performance standby may answer with stale data
+	ConsistencyNone = "none"
+	// ConsistencyForwardActiveNode forwards every request to the active node, simple but it's all load on one node
+	ConsistencyForwardActiveNode = "forward-active-node"
+	// ConsistencyIndexForward sends the known indexes and lets a standby that is behind forward the request
+	ConsistencyIndexForward = "index-forward"
+	// ConsistencyIndexRetry sends the known indexes and retries when a standby that is behind answers with 412
+	ConsistencyIndexRetry = "index-retry"
+)
+
+// Consistency tells how the client deals with reads from performance standbys of vault enterprise
+// It's shared by every client so the index returned by a login is sent along with the reads that follow
+type Consistency struct {
+	Strategy string
+	// Number of retries and the time between them for the index-retry strategy
+	Retries int
+	Backoff time.Duration
+
+	mu      sync.Mutex
+	indexes []string
+}
+
+// NewConsistency returns the consistency of the strategy
+// index is the X-Vault-Index of a write made by someone else, ex. the pipeline that wrote the secret
+func NewConsistency(strategy, index string, retries int, backoff time.Duration) (*Consistency, error) {
+	switch strategy {
+	case "":
+		strategy = ConsistencyNone
+	case ConsistencyNone, ConsistencyForwardActiveNode, ConsistencyIndexForward, ConsistencyIndexRetry:
+	default:
+		return nil, fmt.Errorf("unknown consistency strategy %s, should be one of %s, %s, %s or %s", strategy,
+			ConsistencyNone, ConsistencyForwardActiveNode, ConsistencyIndexForward, ConsistencyIndexRetry)
+	}
+	c := &Consistency{Strategy: strategy, Retries: retries, Backoff: backoff}
+	if index != "" {
+		c.indexes = append(c.indexes, index)
+	}
+	return c, nil
+}
+
+// Add the consistency headers to the request
+func (c *Consistency) apply(req *http.Request) {
+	if c == nil {
+		return
+	}
+	switch c.Strategy {
+	case ConsistencyForwardActiveNode:
+		req.Header.Set("X-Vault-Forward", "active-node")
+	case ConsistencyIndexForward, ConsistencyIndexRetry:
+		c.mu.Lock()
+		for _, index := range c.indexes {
+			req.Header.Add("X-Vault-Index", index)
+		}
+		c.mu.Unlock()
+		if c.Strategy == ConsistencyIndexForward {
+			req.Header.Set("X-Vault-Inconsistent", "forward-active-node")
+		} else {
+			req.Header.Set("X-Vault-Inconsistent", "fail")
+		}
+	}
+}
+
+// Keep the index vault returned, only the newest index of each cluster is kept
+// so the headers don't grow with every write of a long running sync
+func (c *Consistency) record(resp *http.Response) {
+	if c == nil || (c.Strategy != ConsistencyIndexForward && c.Strategy != ConsistencyIndexRetry) {
+		return
+	}
+	index := resp.Header.Get("X-Vault-Index")
+	if index == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes = mergeIndexes(c.indexes, index)
+}
+
+// State of vault an index stands for, the index is the base64 of cluster id:local index:replicated index:hmac
+type indexState struct {
+	cluster    string
+	local      uint64
+	replicated uint64
+}
+
+// Parse an index, an index that can't be parsed is kept as is under an empty cluster id
+func parseIndex(index string) (indexState, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(index)
+	if err != nil {
+		return indexState{}, false
+	}
+	pieces := strings.Split(string(decoded), ":")
+	if len(pieces) != 4 {
+		return indexState{}, false
+	}
+	local, err := strconv.ParseUint(pieces[1], 10, 64)
+	if err != nil {
+		return indexState{}, false
+	}
+	replicated, err := strconv.ParseUint(pieces[2], 10, 64)
+	if err != nil {
+		return indexState{}, false
+	}
+	return indexState{cluster: pieces[0], local: local, replicated: replicated}, true
+}
+
+// Return the indexes with the one of the response, it takes the place of the index of the same cluster
+// unless that one is ahead of it, the responses of the workers don't come back in order
+func mergeIndexes(indexes []string, index string) []string {
+	state, parsed := parseIndex(index)
+	ret := make([]string, 0, len(indexes)+1)
+	for _, i := range indexes {
+		current, ok := parseIndex(i)
+		if ok != parsed || current.cluster != state.cluster {
+			ret = append(ret, i)
+			continue
+		}
+		if parsed && current.local >= state.local && current.replicated >= state.replicated {
+			index = i
+		}
+	}
+	return append(ret, index)
+}
+
+// Tell if the request should be sent again, the standby hasn't caught up with the index yet
+func (c *Consistency) retry(resp *http.Response, attempt int) bool {
+	return c != nil && c.Strategy == ConsistencyIndexRetry &&
+		resp.StatusCode == http.StatusPreconditionFailed && attempt < c.Retries
+}
+
+// SetConsistency configure how the client reads from performance standbys
+func (c *Client) SetConsistency(consistency *Consistency) {
+	c.consistency = consistency
+}
+
+// Send the request with the consistency headers and retry while the standby is behind
+func (c *Client) send(client *http.Client, req *http.Request) (*http.Response, error) {
+	c.consistency.apply(req)
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		c.consistency.record(resp)
+		if !c.consistency.retry(resp, attempt) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		logger.Printf("vault is behind the index for url: %s, retrying in %s", req.URL, c.consistency.Backoff)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(c.consistency.Backoff):
+		}
+
+		// The body was consumed by the previous attempt
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package apis
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewConsistency(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", strategy: "", want: ConsistencyNone},
+		{name: "forward", strategy: "forward-active-node", want: ConsistencyForwardActiveNode},
+		{name: "retry", strategy: "index-retry", want: ConsistencyIndexRetry},
+		{name: "unknown", strategy: "eventual", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewConsistency(tt.strategy, "", 0, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewConsistency() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Strategy != tt.want {
+				t.Errorf("NewConsistency() strategy = %v, want %v", got.Strategy, tt.want)
+			}
+		})
+	}
+}
+
+// Return an index the way vault sends it in X-Vault-Index
+func vaultIndex(cluster string, local, replicated uint64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d:%d:686d6163", cluster, local, replicated)))
+}
+
+func TestClient_Consistency(t *testing.T) {
+	// The secret was written by a pipeline on the primary cluster, the login is answered by the secondary
+	pipelineWrite, login := vaultIndex("primary", 10, 10), vaultIndex("secondary", 4, 10)
+	tests := []struct {
+		name         string
+		strategy     string
+		behind       int
+		wantRequests int
+		wantHeaders  map[string][]string
+		wantErr      bool
+	}{
+		{
+			name:         "none",
+			strategy:     ConsistencyNone,
+			wantRequests: 2,
+			wantHeaders:  map[string][]string{},
+		},
+		{
+			name:         "forward-active-node",
+			strategy:     ConsistencyForwardActiveNode,
+			wantRequests: 2,
+			wantHeaders:  map[string][]string{"X-Vault-Forward": {"active-node"}},
+		},
+		{
+			name:         "index-forward",
+			strategy:     ConsistencyIndexForward,
+			wantRequests: 2,
+			wantHeaders: map[string][]string{
+				"X-Vault-Index":        {pipelineWrite, login},
+				"X-Vault-Inconsistent": {"forward-active-node"},
+			},
+		},
+		{
+			name:         "index-retry",
+			strategy:     ConsistencyIndexRetry,
+			behind:       2,
+			wantRequests: 4,
+			wantHeaders: map[string][]string{
+				"X-Vault-Index":        {pipelineWrite, login},
+				"X-Vault-Inconsistent": {"fail"},
+			},
+		},
+		{
+			name:         "index-retry-exhausted",
+			strategy:     ConsistencyIndexRetry,
+			behind:       5,
+			wantRequests: 5,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			var headers http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.URL.Path == "/v1/auth/approle/login" {
+					w.Header().Set("X-Vault-Index", login)
+					w.Write([]byte(`{"auth":{"client_token": "token"}}`))
+					return
+				}
+				headers = r.Header
+				// The standby hasn't caught up for the first reads
+				if requests-1 <= tt.behind {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					return
+				}
+				w.Write([]byte(`{"data":{"data":{"password": "new"}}}`))
+			}))
+			defer server.Close()
+
+			consistency, err := NewConsistency(tt.strategy, pipelineWrite, 3, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Client{}
+			c.SetConsistency(consistency)
+			if _, err := c.Login(context.Background(), []byte(`{}`), server.URL+"/v1/auth/approle/login", ""); err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.GetData(context.Background(), "token", server.URL+"/v1/secret/data/app", "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("GetData() requests = %v, want %v", requests, tt.wantRequests)
+			}
+			for k, v := range tt.wantHeaders {
+				if got := headers.Values(k); !reflect.DeepEqual(got, v) {
+					t.Errorf("GetData() header %s = %v, want %v", k, got, v)
+				}
+			}
+			if tt.strategy == ConsistencyNone && (headers.Get("X-Vault-Index") != "" || headers.Get("X-Vault-Forward") != "") {
+				t.Errorf("GetData() sent consistency headers %v", headers)
+			}
+		})
+	}
+}
+
+func TestConsistency_record(t *testing.T) {
+	tests := []struct {
+		name    string
+		indexes []string
+		want    []string
+	}{
+		{
+			name:    "newer",
+			indexes: []string{vaultIndex("primary", 1, 1), vaultIndex("primary", 2, 1), vaultIndex("primary", 3, 2)},
+			want:    []string{vaultIndex("primary", 3, 2)},
+		},
+		{
+			// The responses of the workers don't come back in order
+			name:    "older",
+			indexes: []string{vaultIndex("primary", 3, 2), vaultIndex("primary", 2, 1)},
+			want:    []string{vaultIndex("primary", 3, 2)},
+		},
+		{
+			name:    "clusters",
+			indexes: []string{vaultIndex("primary", 5, 5), vaultIndex("secondary", 1, 5), vaultIndex("primary", 6, 5)},
+			want:    []string{vaultIndex("secondary", 1, 5), vaultIndex("primary", 6, 5)},
+		},
+		{
+			name:    "opaque",
+			indexes: []string{"pipeline-write", vaultIndex("primary", 1, 1), "login"},
+			want:    []string{vaultIndex("primary", 1, 1), "login"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewConsistency(ConsistencyIndexRetry, "", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, index := range tt.indexes {
+				c.record(&http.Response{Header: http.Header{"X-Vault-Index": {index}}})
+			}
+			if !reflect.DeepEqual(c.indexes, tt.want) {
+				t.Errorf("record() indexes = %v, want %v", c.indexes, tt.want)
+			}
+		})
+	}
+
+	// A long running sync keeps writing, the headers stay the same size
+	c, err := NewConsistency(ConsistencyIndexForward, vaultIndex("primary", 1, 1), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(2); i <= 1000; i++ {
+		c.record(&http.Response{Header: http.Header{"X-Vault-Index": {vaultIndex("primary", i, i)}}})
+	}
+	req := httptest.NewRequest(http.MethodGet, "/v1/secret/data/app", nil)
+	c.apply(req)
+	if got, want := req.Header.Values("X-Vault-Index"), []string{vaultIndex("primary", 1000, 1000)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("apply() X-Vault-Index = %v, want %v", got, want)
+	}
+}
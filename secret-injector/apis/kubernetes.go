@@ -2,19 +2,44 @@ package apis
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
 )
 
+// Configure the http client for the cluster, adding the client certificate if there's one
+func (c *Client) kubernetes(cluster *models.Cluster) (*http.Client, error) {
+	if cluster == nil || cluster.Server == "" {
+		return nil, fmt.Errorf("kubernetes api server is not configured")
+	}
+
+	var certs []tls.Certificate
+	if len(cluster.CertData) != 0 {
+		cert, err := tls.X509KeyPair(cluster.CertData, cluster.KeyData)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load kubernetes client certificate: %s", err)
+		}
+		certs = append(certs, cert)
+	}
+	return c.httpClient.Mtls(cluster.CA, certs, cluster.Insecure), nil
+}
+
 // Get check if the secret object is already configured
-func (c *Client) Get(token, host, ns, objectName, secretName string, ca []byte) (interface{}, error) {
-	client := c.httpClient.Https(ca)
+func (c *Client) Get(ctx context.Context, cluster *models.Cluster, ns, objectName, secretName string) (interface{}, error) {
+	client, err := c.kubernetes(cluster)
+	if err != nil {
+		return nil, err
+	}
 
-	requestUrl := fmt.Sprintf("https://%s/api/v1/namespaces/%s/%s/%s", host, ns, objectName, secretName)
+	requestUrl := fmt.Sprintf("%s/api/v1/namespaces/%s/%s/%s", cluster.Server, ns, objectName, secretName)
 	// Instantiate an http request
-	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to construct request to kubernetes api: %s", requestUrl)
 	}
@@ -22,11 +47,12 @@ func (c *Client) Get(token, host, ns, objectName, secretName string, ca []byte)
 	req.Header.Set("Accept", "application/json")
 	// Set the content type in http request
 	req.Header.Set("Content-Type", "application/json")
-	// Set the authorization bearer adding the token
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	// Set the authorization bearer adding the token, a client certificate doesn't need one
+	if cluster.Token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cluster.Token))
+	}
 	// Set the user-agent so it will be identifiable in the logs
-	// Initially set to the name of the application, add the version of it in the future
-	req.Header.Set("User-Agent", "vault-gopher")
+	req.Header.Set("User-Agent", UserAgent)
 	// Send the actual request
 	resp, err := client.Do(req)
 	if err != nil {
@@ -40,22 +66,25 @@ func (c *Client) Get(token, host, ns, objectName, secretName string, ca []byte)
 }
 
 // Create creates a secret object in Kubernetes
-func (c *Client) Create(token, host, ns, objectName, secretName string, status int, ca, payload []byte) (map[string]interface{}, error) {
-	client := c.httpClient.Https(ca)
+func (c *Client) Create(ctx context.Context, cluster *models.Cluster, ns, objectName, secretName string, status int, payload []byte) (map[string]interface{}, error) {
+	client, err := c.kubernetes(cluster)
+	if err != nil {
+		return nil, err
+	}
 
 	// We switch method depending on the status, otherwise error will occur if object exist
 	var method string
 	var requestUrl string
 	if status == 200 {
 		method = http.MethodPut
-		requestUrl = fmt.Sprintf("https://%s/api/v1/namespaces/%s/%s/%s", host, ns, objectName, secretName)
+		requestUrl = fmt.Sprintf("%s/api/v1/namespaces/%s/%s/%s", cluster.Server, ns, objectName, secretName)
 	} else {
 		// else use the POST method
 		method = http.MethodPost
-		requestUrl = fmt.Sprintf("https://%s/api/v1/namespaces/%s/%s", host, ns, objectName)
+		requestUrl = fmt.Sprintf("%s/api/v1/namespaces/%s/%s", cluster.Server, ns, objectName)
 	}
 	// Instantiate an http request
-	req, err := http.NewRequest(method, requestUrl, bytes.NewBuffer(payload))
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, bytes.NewBuffer(payload))
 	if err != nil {
 		return nil, fmt.Errorf("failed to construct request to kubernetes api: %s", requestUrl)
 	}
@@ -63,11 +92,12 @@ func (c *Client) Create(token, host, ns, objectName, secretName string, status i
 	req.Header.Set("Accept", "application/json")
 	// Set the content type in http request
 	req.Header.Set("Content-Type", "application/json")
-	// Set the authorization bearer adding the token
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	// Set the authorization bearer adding the token, a client certificate doesn't need one
+	if cluster.Token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cluster.Token))
+	}
 	// Set the user-agent so it will be identifiable in the logs
-	// Initially set to the name of the application, add the version of it in the future
-	req.Header.Set("User-Agent", "vault-gopher")
+	req.Header.Set("User-Agent", UserAgent)
 	// Send the actual request
 	resp, err := client.Do(req)
 	if err != nil {
@@ -90,3 +120,217 @@ func (c *Client) Create(token, host, ns, objectName, secretName string, status i
 	return ret, nil
 }
 
+// GetSecret fetch the secret object and return the status code together with the object if it's present
+func (c *Client) GetSecret(ctx context.Context, cluster *models.Cluster, ns, objectName, secretName string) (int, map[string]interface{}, error) {
+	client, err := c.kubernetes(cluster)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	requestUrl := fmt.Sprintf("%s/api/v1/namespaces/%s/%s/%s", cluster.Server, ns, objectName, secretName)
+	// Instantiate an http request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to construct request to kubernetes api: %s", requestUrl)
+	}
+	// Set the accepted content type in request
+	req.Header.Set("Accept", "application/json")
+	// Set the authorization bearer adding the token, a client certificate doesn't need one
+	if cluster.Token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cluster.Token))
+	}
+	// Set the user-agent so it will be identifiable in the logs
+	req.Header.Set("User-Agent", UserAgent)
+	// Send the actual request
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send request to kubernetes api: %s", err)
+	}
+	defer resp.Body.Close()
+
+	logger.LogGopher(resp, req)
+
+	// Only a found object has a body worth returning
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error reading response body")
+	}
+	var ret map[string]interface{}
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return 0, nil, fmt.Errorf("error handling the payload")
+	}
+	return resp.StatusCode, ret, nil
+}
+
+// CreateEvent post an event to the events.k8s.io api so it shows up when the object is described
+func (c *Client) CreateEvent(ctx context.Context, cluster *models.Cluster, ns string, payload []byte) error {
+	client, err := c.kubernetes(cluster)
+	if err != nil {
+		return err
+	}
+
+	requestUrl := fmt.Sprintf("%s/apis/events.k8s.io/v1/namespaces/%s/events", cluster.Server, ns)
+	// Instantiate an http request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestUrl, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to construct request to kubernetes api: %s", requestUrl)
+	}
+	// Set the accepted content type in request
+	req.Header.Set("Accept", "application/json")
+	// Set the content type in http request
+	req.Header.Set("Content-Type", "application/json")
+	// Set the authorization bearer adding the token, a client certificate doesn't need one
+	if cluster.Token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cluster.Token))
+	}
+	// Set the user-agent so it will be identifiable in the logs
+	req.Header.Set("User-Agent", UserAgent)
+	// Send the actual request
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request to kubernetes api: %s", err)
+	}
+	defer resp.Body.Close()
+
+	logger.LogGopher(resp, req)
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("event was not created, kubernetes api responded with: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ListNamespaces return the name of the namespaces that match the label selector
+func (c *Client) ListNamespaces(ctx context.Context, cluster *models.Cluster, selector string) ([]string, error) {
+	client, err := c.kubernetes(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	requestUrl := fmt.Sprintf("%s/api/v1/namespaces?labelSelector=%s", cluster.Server, url.QueryEscape(selector))
+	// Instantiate an http request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct request to kubernetes api: %s", requestUrl)
+	}
+	// Set the accepted content type in request
+	req.Header.Set("Accept", "application/json")
+	// Set the authorization bearer adding the token, a client certificate doesn't need one
+	if cluster.Token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cluster.Token))
+	}
+	// Set the user-agent so it will be identifiable in the logs
+	req.Header.Set("User-Agent", UserAgent)
+	// Send the actual request
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to kubernetes api: %s", err)
+	}
+	defer resp.Body.Close()
+
+	logger.LogGopher(resp, req)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot list namespaces, kubernetes api responded with: %d", resp.StatusCode)
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error handling the payload")
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.Metadata.Name)
+	}
+	return names, nil
+}
+
+// ListSecrets return the secret objects of the namespace that match the label selector
+func (c *Client) ListSecrets(ctx context.Context, cluster *models.Cluster, ns, objectName, selector string) ([]map[string]interface{}, error) {
+	client, err := c.kubernetes(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	requestUrl := fmt.Sprintf("%s/api/v1/namespaces/%s/%s?labelSelector=%s", cluster.Server, ns, objectName, url.QueryEscape(selector))
+	// Instantiate an http request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct request to kubernetes api: %s", requestUrl)
+	}
+	// Set the accepted content type in request
+	req.Header.Set("Accept", "application/json")
+	// Set the authorization bearer adding the token, a client certificate doesn't need one
+	if cluster.Token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cluster.Token))
+	}
+	// Set the user-agent so it will be identifiable in the logs
+	req.Header.Set("User-Agent", UserAgent)
+	// Send the actual request
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to kubernetes api: %s", err)
+	}
+	defer resp.Body.Close()
+
+	logger.LogGopher(resp, req)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot list secrets, kubernetes api responded with: %d", resp.StatusCode)
+	}
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error handling the payload")
+	}
+	return list.Items, nil
+}
+
+// Restart rolls the pods of a workload the way kubectl rollout restart does, by changing an annotation of its template
+// The resource is the plural of the apps/v1 kind, ex. deployments, the status code is returned so a missing workload can be told apart
+func (c *Client) Restart(ctx context.Context, cluster *models.Cluster, ns, resource, name, restartedAt string) (int, error) {
+	client, err := c.kubernetes(cluster)
+	if err != nil {
+		return 0, err
+	}
+
+	requestUrl := fmt.Sprintf("%s/apis/apps/v1/namespaces/%s/%s/%s", cluster.Server, ns, resource, name)
+	payload := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":%q}}}}}`, restartedAt)
+	// Instantiate an http request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, requestUrl, bytes.NewBufferString(payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to construct request to kubernetes api: %s", requestUrl)
+	}
+	// Set the accepted content type in request
+	req.Header.Set("Accept", "application/json")
+	// Set the content type in http request
+	req.Header.Set("Content-Type", "application/strategic-merge-patch+json")
+	// Set the authorization bearer adding the token, a client certificate doesn't need one
+	if cluster.Token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", cluster.Token))
+	}
+	// Set the user-agent so it will be identifiable in the logs
+	req.Header.Set("User-Agent", UserAgent)
+	// Send the actual request
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request to kubernetes api: %s", err)
+	}
+	defer resp.Body.Close()
+
+	logger.LogGopher(resp, req)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return resp.StatusCode, fmt.Errorf("cannot restart %s/%s, kubernetes api responded with: %d", resource, name, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -1,6 +1,7 @@
 package apis
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/trx35479/vault-gopher/secret-injector/client"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
 )
 
 func TestClient_Get(t *testing.T) {
@@ -18,12 +20,10 @@ func TestClient_Get(t *testing.T) {
 		httpClient client.Client
 	}
 	type args struct {
-		token      string
-		host       string
+		cluster    *models.Cluster
 		ns         string
 		objectName string
 		secretName string
-		ca         []byte
 	}
 	caCrt, err := ioutil.ReadFile("../../testdata/test.crt")
 	if err != nil {
@@ -40,12 +40,14 @@ func TestClient_Get(t *testing.T) {
 			name:    "TestGet",
 			fields:  fields{},
 			args:    args{
-				token: "token",
-				host: "127.0.0.1",
+				cluster: &models.Cluster{
+					Server: "https://127.0.0.1",
+					Token:  "token",
+					CA:     caCrt,
+				},
 				ns: "ops-sre",
 				objectName: "secrets",
 				secretName: "test-secret",
-				ca: caCrt,
 			},
 			want:    200,
 			wantErr: false,
@@ -83,7 +85,7 @@ func TestClient_Get(t *testing.T) {
 			server.StartTLS()
 			defer server.Close()
 			time.Sleep(100 * time.Millisecond)
-			got, err := c.Get(tt.args.token, tt.args.host, tt.args.ns, tt.args.objectName, tt.args.secretName, tt.args.ca)
+			got, err := c.Get(context.Background(), tt.args.cluster, tt.args.ns, tt.args.objectName, tt.args.secretName)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -100,13 +102,11 @@ func TestClient_Create(t *testing.T) {
 		httpClient client.Client
 	}
 	type args struct {
-		token      string
-		host       string
+		cluster    *models.Cluster
 		ns         string
 		objectName string
 		secretName string
 		status     int
-		ca         []byte
 		payload    []byte
 	}
 	caCrt, err := ioutil.ReadFile("../../testdata/test.crt")
@@ -160,13 +160,15 @@ func TestClient_Create(t *testing.T) {
 			name:    "TestCreate",
 			fields:  fields{},
 			args:    args{
-				token: "token",
-				host: "127.0.0.1",
+				cluster: &models.Cluster{
+					Server: "https://127.0.0.1",
+					Token:  "token",
+					CA:     caCrt,
+				},
 				ns: "ops-sre",
 				objectName: "secrets",
 				secretName: "tls",
 				status: 200,
-				ca: caCrt,
 				payload: object,
 			},
 			want:    ret,
@@ -204,7 +206,7 @@ func TestClient_Create(t *testing.T) {
 			server.StartTLS()
 			defer server.Close()
 			time.Sleep(100 * time.Millisecond)
-			got, err := c.Create(tt.args.token, tt.args.host, tt.args.ns, tt.args.objectName, tt.args.secretName, tt.args.status, tt.args.ca, tt.args.payload)
+			got, err := c.Create(context.Background(), tt.args.cluster, tt.args.ns, tt.args.objectName, tt.args.secretName, tt.args.status, tt.args.payload)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Create() error = %v, wantErr %v", err, tt.wantErr)
 				return
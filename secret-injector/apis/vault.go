@@ -1,10 +1,13 @@
 package apis
 
-
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -17,26 +20,65 @@ import (
 
 type Client struct {
 	httpClient client.Client
+
+	// TLS settings of the connection to vault, ex. the client certificate of the cert auth method
+	vaultCA       []byte
+	vaultCerts    []tls.Certificate
+	vaultInsecure bool
+
+	// Read-after-write consistency with the performance standbys of vault enterprise
+	consistency *Consistency
 }
 
 var logger = log.NewLogger()
 
+// UserAgent identifies the app in the logs of vault and kubernetes, the main package adds the version to it
+var UserAgent = "vault-gopher"
+
+// SetVaultTLS configure the ca, client certificates and verification used when talking to vault
+func (c *Client) SetVaultTLS(ca []byte, certs []tls.Certificate, insecure bool) {
+	c.vaultCA = ca
+	c.vaultCerts = certs
+	c.vaultInsecure = insecure
+}
+
+// Return the http client for vault, plain https unless SetVaultTLS was called
+func (c *Client) vault() *http.Client {
+	if len(c.vaultCA) == 0 && len(c.vaultCerts) == 0 {
+		return c.httpClient.Http(c.vaultInsecure)
+	}
+	return c.httpClient.Mtls(c.vaultCA, c.vaultCerts, c.vaultInsecure)
+}
+
 // GetClientToken function to get the needed token before data can be provided by vault
 // Note that we will use the kubernetes auth on vault
-func (c *Client) GetClientToken(requestBody []byte, url, namespace string) (interface{}, error) {
-	client := c.httpClient.Http(false)
+func (c *Client) GetClientToken(ctx context.Context, requestBody []byte, url, namespace string) (interface{}, error) {
+	token, err := c.Login(ctx, requestBody, url, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientToken string
+	clientToken = token.Auth.ClientToken
+
+	return clientToken, nil
+}
+
+// Login send the login request of an auth method and return the whole payload
+// the auth block holds the client token together with its policies and ttl
+func (c *Client) Login(ctx context.Context, requestBody []byte, url, namespace string) (*models.Payload, error) {
+	client := c.vault()
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, fmt.Errorf("error creating the request for url: %s", url)
 	}
 	// Add namespace header before sent to the vault server
 	req.Header.Add("X-Vault-Namespace", namespace)
 	// Set the user-agent so it will be identifiable in the logs
-	// Initially set to the name of the application, add the version of it in the future
-	req.Header.Set("User-Agent", "vault-gopher")
+	req.Header.Set("User-Agent", UserAgent)
 	// Send the request to Vault
-	resp, err := client.Do(req)
+	resp, err := c.send(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request to vault api for url: %s", url)
 	}
@@ -57,18 +99,17 @@ func (c *Client) GetClientToken(requestBody []byte, url, namespace string) (inte
 	if err != nil {
 		return nil, fmt.Errorf("error handling the payload")
 	}
-
-	var clientToken string
-	clientToken = token.Auth.ClientToken
-
-	return clientToken, nil
+	if token.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("no client token found in the payload")
+	}
+	return token, nil
 }
 
 // GetData function to get the secret data from vault
 // This should be executed after the login is successful
-func (c *Client) GetData(token, url, namespace string) (map[string]interface{}, error) {
-	client := c.httpClient.Http(false)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func (c *Client) GetData(ctx context.Context, token, url, namespace string) (map[string]interface{}, error) {
+	client := c.vault()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating the request for url: %s", url)
 	}
@@ -77,10 +118,9 @@ func (c *Client) GetData(token, url, namespace string) (map[string]interface{},
 	// Add namespace header before sent to the vault server
 	req.Header.Add("X-Vault-Namespace", namespace)
 	// Set the user-agent so it will be identifiable in the logs
-	// Initially set to the name of the application, add the version of it in the future
-	req.Header.Set("User-Agent", "vault-gopher")
+	req.Header.Set("User-Agent", UserAgent)
 	// Send the request to Vault
-	resp, err := client.Do(req)
+	resp, err := c.send(client, req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending request to vault api for url: %s", url)
 	}
@@ -106,7 +146,12 @@ func (c *Client) GetData(token, url, namespace string) (map[string]interface{},
 			return nil, fmt.Errorf("error handling the payload")
 		}
 
-		rData := data.Data.Data.(map[string]interface{})
+		// A deleted or destroyed version of a kv v2 secret comes back with a 404, no data and no errors
+		rData, ok := data.Data.Data.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no data found for url: %s, vault responded with: %d, the version may have been deleted or destroyed",
+				url, resp.StatusCode)
+		}
 
 		return rData, nil
 	}
@@ -114,10 +159,10 @@ func (c *Client) GetData(token, url, namespace string) (map[string]interface{},
 }
 
 // RevokeToken function revoke self token so vault won't have to keep the token alive for 900s
-func (c *Client) RevokeToken(vaultAddress, path, token, namespace string) (ok bool, err error) {
-	client := c.httpClient.Http(false)
+func (c *Client) RevokeToken(ctx context.Context, vaultAddress, path, token, namespace string) (ok bool, err error) {
+	client := c.vault()
 	requestUrl := fmt.Sprintf("%s/v1/%s", vaultAddress, path)
-	req, err := http.NewRequest(http.MethodPost, requestUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestUrl, nil)
 	if err != nil {
 		return false, fmt.Errorf("error creating the request for url: %s", requestUrl)
 	}
@@ -126,15 +171,16 @@ func (c *Client) RevokeToken(vaultAddress, path, token, namespace string) (ok bo
 	// Add namespace header before sent to the vault server
 	req.Header.Add("X-Vault-Namespace", namespace)
 	// Set the user-agent so it will be identifiable in the logs
-	// Initially set to the name of the application, add the version of it in the future
-	req.Header.Set("User-Agent", "vault-gopher")
+	req.Header.Set("User-Agent", UserAgent)
 	// Send the request to Vault
-	resp, err := client.Do(req)
+	resp, err := c.send(client, req)
 	if err != nil {
 		return false, fmt.Errorf("error sending request to vault api for url: %s", requestUrl)
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	// Vault answers 204 No Content when the token is revoked
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return false, fmt.Errorf("error response from vault api for url: %s", requestUrl)
 	}
 	return true, nil
@@ -142,31 +188,34 @@ func (c *Client) RevokeToken(vaultAddress, path, token, namespace string) (ok bo
 
 // GetStatus is fix to query the status of vault endpoint
 // This is especially if you are using istio service mesh in kubernetes cluster
-func (c *Client) GetStatus(address, path string) error {
-	client := c.httpClient.Http(false)
+// It keeps trying every second until vault answers or the context is done
+func (c *Client) GetStatus(ctx context.Context, address, path string) error {
+	client := c.vault()
 
 	// Loop and send the request in an 1 sec interval
-	for i := 0; ; i++ {
-		url := fmt.Sprintf("%s/v1/%s", strings.Trim(address, "/"), strings.Trim(path, "/"))
-		req, err := http.NewRequest(http.MethodGet, url, nil)
+	url := fmt.Sprintf("%s/v1/%s", strings.Trim(address, "/"), strings.Trim(path, "/"))
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
 			return fmt.Errorf("error creating the request for url: %s", url)
 		}
-		req.Header.Set("User-Agent", "vault-gopher")
+		req.Header.Set("User-Agent", UserAgent)
 
 		resp, err := client.Do(req)
-		if err != nil {
-			time.Sleep(1 * time.Second)
-			continue
+		if err == nil {
+			logger.LogGopher(resp, req)
+			resp.Body.Close()
+			if resp.StatusCode == 200 || resp.StatusCode == 429 {
+				return nil
+			}
 		}
 
-		logger.LogGopher(resp, req)
-
-		if resp.StatusCode == 200 || resp.StatusCode == 429 {
-			break
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("vault is not ready at url: %s: %s", url, ctx.Err())
+		case <-time.After(1 * time.Second):
 		}
 	}
-	return nil
 }
 
 // checkError function to find error key in the slice return bool
@@ -175,7 +224,9 @@ func checkError(data []byte) bool {
 
 	err := json.Unmarshal([]byte(data), &payload)
 	if err != nil {
-		logger.Fatal(err)
+		// A payload we can't read is as good as an error, ex. an html page from a proxy in front of vault
+		logger.Error(err)
+		return true
 	}
 	for k := range payload {
 		if k == "errors" {
@@ -184,3 +235,297 @@ func checkError(data []byte) bool {
 	}
 	return false
 }
+
+// LookupSelf function to check a token we didn't get from a login and return what vault knows about it
+func (c *Client) LookupSelf(ctx context.Context, token, url, namespace string) (*models.TokenInfo, error) {
+	client := c.vault()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request for url: %s", url)
+	}
+	// Add the header X-Vault-Token in the http request
+	req.Header.Add("X-Vault-Token", token)
+	// Add namespace header before sent to the vault server
+	req.Header.Add("X-Vault-Namespace", namespace)
+	// Set the user-agent so it will be identifiable in the logs
+	req.Header.Set("User-Agent", UserAgent)
+	// Send the request to Vault
+	resp, err := c.send(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to vault api for url: %s", url)
+	}
+
+	logger.LogGopher(resp, req)
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body")
+	}
+	// Additional check if the payload return by the vault has an error
+	// The status tells a token vault doesn't accept anymore apart from a vault that isn't doing well
+	if checkError(body) {
+		return nil, &ResponseError{StatusCode: resp.StatusCode, Errors: []string{"token was rejected by vault"}}
+	}
+	var info *models.TokenInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("error handling the payload")
+	}
+	return info, nil
+}
+
+// Send a request to vault and decode the generic payload
+// Every status other than 2xx is returned as an error together with the errors vault gave us
+func (c *Client) request(ctx context.Context, method, url, token, namespace string, body []byte) (*models.Secret, error) {
+	client := c.vault()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewBuffer(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the request for url: %s", url)
+	}
+	// Add the header X-Vault-Token in the http request, some endpoints don't need one
+	if token != "" {
+		req.Header.Add("X-Vault-Token", token)
+	}
+	// Add namespace header before sent to the vault server
+	req.Header.Add("X-Vault-Namespace", namespace)
+	// Set the user-agent so it will be identifiable in the logs
+	req.Header.Set("User-Agent", UserAgent)
+	// Send the request to Vault
+	resp, err := c.send(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to vault api for url: %s", url)
+	}
+
+	logger.LogGopher(resp, req)
+
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body")
+	}
+
+	var payload models.Secret
+	// 204 and some errors have no body at all
+	if len(bytes.TrimSpace(data)) != 0 {
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, fmt.Errorf("error handling the payload")
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &ResponseError{StatusCode: resp.StatusCode, Errors: payload.Errors}
+	}
+	return &payload, nil
+}
+
+// ResponseError is returned when vault answers with a non 2xx status
+type ResponseError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *ResponseError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault responded with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault responded with status %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+// LookupWrapping function to read the creation path and ttl of a wrapping token without unwrapping it
+// A token that was already unwrapped or has expired is rejected by vault
+func (c *Client) LookupWrapping(ctx context.Context, wrappingToken, url, namespace string) (*models.Secret, error) {
+	body, err := json.Marshal(map[string]string{"token": wrappingToken})
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct json payload for wrapping lookup")
+	}
+	return c.request(ctx, http.MethodPost, url, "", namespace, body)
+}
+
+// Unwrap function to return the response wrapped by the token, the token can't be used after this
+func (c *Client) Unwrap(ctx context.Context, wrappingToken, url, namespace string) (*models.Secret, error) {
+	return c.request(ctx, http.MethodPost, url, wrappingToken, namespace, nil)
+}
+
+// List function to return the keys under a path of the kv metadata, the folders end with a slash
+// vault answers 404 for a folder without anything in it, which is not an error for us
+func (c *Client) List(ctx context.Context, token, url, namespace string) ([]string, error) {
+	payload, err := c.request(ctx, "LIST", url, token, namespace, nil)
+	if err != nil {
+		if e, ok := err.(*ResponseError); ok && e.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	values, _ := payload.Data["keys"].([]interface{})
+	keys := make([]string, 0, len(values))
+	for _, value := range values {
+		if key, ok := value.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// GetMetadata function to read the current version of a kv v2 secret without reading the secret itself
+func (c *Client) GetMetadata(ctx context.Context, token, url, namespace string) (*models.Version, error) {
+	payload, err := c.request(ctx, http.MethodGet, url, token, namespace, nil)
+	if err != nil {
+		return nil, err
+	}
+	version, ok := payload.Data["current_version"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("no current_version found in the metadata of url: %s", url)
+	}
+	updated, _ := payload.Data["updated_time"].(string)
+	return &models.Version{Version: int(version), UpdatedTime: updated}, nil
+}
+
+// Decrypt function to decrypt transit ciphertext, ex. vault:v1:..., in a single batch
+// The plaintexts are returned in the order of the ciphertexts, a single one that fails fails the batch
+func (c *Client) Decrypt(ctx context.Context, token, url, namespace string, ciphertexts []string) ([]string, error) {
+	input := make([]map[string]string, 0, len(ciphertexts))
+	for _, ciphertext := range ciphertexts {
+		input = append(input, map[string]string{"ciphertext": ciphertext})
+	}
+	body, err := json.Marshal(map[string]interface{}{"batch_input": input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct json payload for transit decrypt")
+	}
+	payload, err := c.request(ctx, http.MethodPost, url, token, namespace, body)
+	if err != nil {
+		return nil, err
+	}
+
+	results, _ := payload.Data["batch_results"].([]interface{})
+	if len(results) != len(ciphertexts) {
+		return nil, fmt.Errorf("vault returned %d results for %d ciphertexts", len(results), len(ciphertexts))
+	}
+	ret := make([]string, 0, len(results))
+	for i, value := range results {
+		result, _ := value.(map[string]interface{})
+		if msg, ok := result["error"].(string); ok && msg != "" {
+			return nil, fmt.Errorf("cannot decrypt ciphertext %d: %s", i, msg)
+		}
+		// The plaintext is always base64 encoded by transit
+		encoded, _ := result["plaintext"].(string)
+		plaintext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("plaintext %d is not base64 encoded", i)
+		}
+		ret = append(ret, string(plaintext))
+	}
+	return ret, nil
+}
+
+// SignSSH function to sign a public key with a role of the ssh secrets engine and return the certificate
+func (c *Client) SignSSH(ctx context.Context, token, url, namespace string, request map[string]interface{}) (string, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to construct json payload for ssh sign")
+	}
+	payload, err := c.request(ctx, http.MethodPost, url, token, namespace, body)
+	if err != nil {
+		return "", err
+	}
+	signed, _ := payload.Data["signed_key"].(string)
+	if signed == "" {
+		return "", fmt.Errorf("no signed_key found in the payload of url: %s", url)
+	}
+	return signed, nil
+}
+
+// ReadSecret function to read a secret with its lease, ex. dynamic credentials
+// The request is a POST when there's a body, vault takes the parameters of most endpoints either way
+func (c *Client) ReadSecret(ctx context.Context, token, url, namespace string, body map[string]interface{}) (*models.Secret, error) {
+	if len(body) == 0 {
+		return c.request(ctx, http.MethodGet, url, token, namespace, nil)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct json payload for url: %s", url)
+	}
+	return c.request(ctx, http.MethodPost, url, token, namespace, data)
+}
+
+// RenewLease function to extend the lease of a secret, vault decides how much it's actually extended
+func (c *Client) RenewLease(ctx context.Context, token, url, namespace, leaseId string, increment int) (*models.Secret, error) {
+	body, err := json.Marshal(map[string]interface{}{"lease_id": leaseId, "increment": increment})
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct json payload for lease renewal")
+	}
+	return c.request(ctx, http.MethodPut, url, token, namespace, body)
+}
+
+// ReadKV function to read a kv v2 secret together with its version
+// A secret that doesn't exist has no data and version 0, which is what check-and-set expects to create it
+func (c *Client) ReadKV(ctx context.Context, token, url, namespace string) (map[string]interface{}, int, error) {
+	payload, err := c.request(ctx, http.MethodGet, url, token, namespace, nil)
+	if err != nil {
+		if e, ok := err.(*ResponseError); ok && e.StatusCode == http.StatusNotFound {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	data, _ := payload.Data["data"].(map[string]interface{})
+	metadata, _ := payload.Data["metadata"].(map[string]interface{})
+	version, _ := metadata["version"].(float64)
+	return data, int(version), nil
+}
+
+// WriteKV function to write a new version of a kv v2 secret
+// The write fails when the secret is not at the version given, so nothing written in between is lost
+func (c *Client) WriteKV(ctx context.Context, token, url, namespace string, data map[string]interface{}, cas int) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"options": map[string]interface{}{"cas": cas},
+		"data":    data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to construct json payload for url: %s", url)
+	}
+	_, err = c.request(ctx, http.MethodPost, url, token, namespace, body)
+	return err
+}
+
+// GeneratePassword function to generate a value from a password policy of vault
+func (c *Client) GeneratePassword(ctx context.Context, token, url, namespace string) (string, error) {
+	payload, err := c.request(ctx, http.MethodGet, url, token, namespace, nil)
+	if err != nil {
+		return "", err
+	}
+	password, _ := payload.Data["password"].(string)
+	if password == "" {
+		return "", fmt.Errorf("no password found in the payload of url: %s", url)
+	}
+	return password, nil
+}
+
+// Capabilities function to read the capabilities of the token on each of the paths with sys/capabilities-self
+// The paths are relative to the namespace, the capabilities of each path are returned under the path
+func (c *Client) Capabilities(ctx context.Context, token, url, namespace string, paths []string) (map[string][]string, error) {
+	body, err := json.Marshal(map[string]interface{}{"paths": paths})
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct json payload for url: %s", url)
+	}
+	payload, err := c.request(ctx, http.MethodPost, url, token, namespace, body)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		values, ok := payload.Data[path].([]interface{})
+		// A single path is only answered with capabilities by older versions of vault
+		if !ok && len(paths) == 1 {
+			values, _ = payload.Data["capabilities"].([]interface{})
+		}
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				ret[path] = append(ret[path], s)
+			}
+		}
+	}
+	return ret, nil
+}
@@ -1,12 +1,21 @@
 package apis
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/trx35479/vault-gopher/secret-injector/client"
 )
@@ -64,7 +73,7 @@ func TestClient_GetClientToken(t *testing.T) {
 				}
 			}))
 			defer server.Close()
-			got, err := c.GetClientToken(tt.args.requestBody, tt.args.url, tt.args.namespace)
+			got, err := c.GetClientToken(context.Background(), tt.args.requestBody, tt.args.url, tt.args.namespace)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetClientToken() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -121,7 +130,7 @@ func TestClient_GetData(t *testing.T) {
 				}
 			}))
 			defer server.Close()
-			got, err := c.GetData(tt.args.token, tt.args.url, tt.args.namespace)
+			got, err := c.GetData(context.Background(), tt.args.token, tt.args.url, tt.args.namespace)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetData() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -131,4 +140,163 @@ func TestClient_GetData(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+// Generate a self signed client certificate for the mtls tests
+func testClientCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vault-gopher"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClient_GetClientTokenCert(t *testing.T) {
+	cert := testClientCertificate(t)
+	tests := []struct {
+		name    string
+		certs   []tls.Certificate
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			name:    "with-client-certificate",
+			certs:   []tls.Certificate{cert},
+			want:    "token",
+			wantErr: false,
+		},
+		{
+			name:    "without-client-certificate",
+			certs:   nil,
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(r.TLS.PeerCertificates) == 0 {
+					t.Error("Client certificate is missing")
+				}
+				var reqBody map[string]interface{}
+				if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+					t.Error("Unexpected body in request")
+				}
+				if reqBody["name"] != "web" {
+					t.Errorf("Missing or incorrect role name %s", reqBody["name"])
+				}
+				fmt.Fprintln(w, `{"auth":{"client_token": "token"}}`)
+			}))
+			server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+			server.StartTLS()
+			defer server.Close()
+
+			c := &Client{}
+			c.SetVaultTLS(nil, tt.certs, true)
+			body, _ := json.Marshal(map[string]string{"name": "web"})
+			got, err := c.GetClientToken(context.Background(), body, server.URL+"/v1/auth/cert/login", "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetClientToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetClientToken() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_RevokeToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:   "no-content",
+			status: http.StatusNoContent,
+			want:   true,
+		},
+		{
+			name:   "ok",
+			status: http.StatusOK,
+			want:   true,
+		},
+		{
+			name:    "forbidden",
+			status:  http.StatusForbidden,
+			want:    false,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/auth/token/revoke-self" {
+					t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if r.Header.Get("X-Vault-Token") != "token" {
+					t.Errorf("Missing or incorrect token %s", r.Header.Get("X-Vault-Token"))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			c := &Client{}
+			got, err := c.RevokeToken(context.Background(), server.URL, "auth/token/revoke-self", "token", "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RevokeToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RevokeToken() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_GetDataDeleted(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "deleted",
+			body: `{"data": {"data": null, "metadata": {"deletion_time": "2026-01-01T00:00:00Z", "destroyed": false, "version": 2}}}`,
+		},
+		{
+			name: "destroyed",
+			body: `{"data": {"data": null, "metadata": {"deletion_time": "", "destroyed": true, "version": 2}}}`,
+		},
+		{
+			name: "no-data",
+			body: `{"data": null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintln(w, tt.body)
+			}))
+			defer server.Close()
+
+			c := &Client{}
+			got, err := c.GetData(context.Background(), "token", server.URL+"/v1/secret/data/app", "")
+			if err == nil {
+				t.Errorf("GetData() got = %v, want an error", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+)
+
+func init() {
+	Register("approle", newAppRole)
+}
+
+// appRole logs in with the role id and the secret id mounted onto the pod
+// With SecretIdWrapped the mounted file is a response wrapping token that is unwrapped first
+type appRole struct {
+	cfg *Config
+	url string
+	// A wrapping token can only be unwrapped once, the secret id is kept for the logins that follow
+	// and unwrapped again only when another wrapping token is mounted
+	mu            sync.Mutex
+	wrappingToken string
+	secretId      string
+}
+
+func newAppRole(method string, cfg *Config) (AuthMethod, error) {
+	if cfg.CredentialPath == "" {
+		return nil, fmt.Errorf("auth method %s needs the path of the secret id", method)
+	}
+	if cfg.SecretIdWrapped && cfg.WrappedCreationPath == "" {
+		// Secret ids are generated by auth/<mount>/role/<role name>/secret-id
+		mount := strings.Trim(cfg.MountPath, "/")
+		if mount == "" {
+			mount = "auth/" + method
+		}
+		cfg.WrappedCreationPath = mount + "/role/*/secret-id"
+	}
+	return &appRole{cfg: cfg, url: loginUrl(method, cfg)}, nil
+}
+
+func (a *appRole) Name() string {
+	return "approle"
+}
+
+func (a *appRole) Login(ctx context.Context) (Token, error) {
+	// Read the mounted secret id
+	data, err := ioutil.ReadFile(a.cfg.CredentialPath)
+	if err != nil {
+		return Token{}, fmt.Errorf("cannot read vault token error %v", err)
+	}
+	secretId := strings.TrimSpace(string(data))
+
+	if a.cfg.SecretIdWrapped {
+		if secretId, err = a.unwrapped(ctx, secretId); err != nil {
+			return Token{}, err
+		}
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"secret_id": secretId,
+		"role_id":   a.cfg.Role,
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to construct json payload for auth method: approle")
+	}
+	return login(ctx, a.cfg, a.url, body)
+}
+
+// Return the secret id of the wrapping token, it's only unwrapped the first time the token is seen
+func (a *appRole) unwrapped(ctx context.Context, wrappingToken string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.secretId != "" && wrappingToken == a.wrappingToken {
+		return a.secretId, nil
+	}
+	secretId, err := a.unwrap(ctx, wrappingToken)
+	if err != nil {
+		return "", err
+	}
+	a.wrappingToken, a.secretId = wrappingToken, secretId
+	return secretId, nil
+}
+
+// Unwrap the secret id, making sure nobody else has unwrapped it or swapped it for another wrapped response
+func (a *appRole) unwrap(ctx context.Context, wrappingToken string) (string, error) {
+	client, err := a.cfg.Client()
+	if err != nil {
+		return "", err
+	}
+	address := strings.TrimRight(a.cfg.Address, "/")
+
+	// A wrapping token can only be unwrapped once, vault rejects the lookup of a token that was used
+	// which means someone else got to the secret id before us
+	info, err := client.LookupWrapping(ctx, wrappingToken, address+"/v1/sys/wrapping/lookup", a.cfg.Namespace)
+	if err != nil {
+		return "", fmt.Errorf("wrapped secret id is not valid, it may have been unwrapped already "+
+			"and the secret id must be treated as compromised: %s", err)
+	}
+	creationPath, _ := info.Data["creation_path"].(string)
+	if ok, _ := path.Match(a.cfg.WrappedCreationPath, strings.Trim(creationPath, "/")); !ok {
+		return "", fmt.Errorf("wrapped secret id was created by %q and not by %q, refusing to unwrap it",
+			creationPath, a.cfg.WrappedCreationPath)
+	}
+
+	payload, err := client.Unwrap(ctx, wrappingToken, address+"/v1/sys/wrapping/unwrap", a.cfg.Namespace)
+	if err != nil {
+		return "", fmt.Errorf("cannot unwrap secret id: %s", err)
+	}
+	secretId, _ := payload.Data["secret_id"].(string)
+	if secretId == "" {
+		return "", fmt.Errorf("wrapped response has no secret_id")
+	}
+	return secretId, nil
+}
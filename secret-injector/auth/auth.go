@@ -0,0 +1,161 @@
+// Package auth logs in to vault with one of the supported auth methods
+// Every method registers itself under its name, the name is chosen explicitly and is
+// independent of the path the method is mounted on, ex. kubernetes mounted at auth/k8s/prod-cluster
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/apis"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// Token is the vault token we got back from the login
+type Token struct {
+	ClientToken string
+	Accessor    string
+	Policies    []string
+	TTL         time.Duration
+	Renewable   bool
+}
+
+// AuthMethod logs in to vault and returns a client token
+type AuthMethod interface {
+	Login(ctx context.Context) (Token, error)
+}
+
+// Named is implemented by the auth methods of the package, Name is the name the method is registered under
+type Named interface {
+	Name() string
+}
+
+// Config holds everything the auth methods may need to login
+type Config struct {
+	// Address of vault, ex. https://vault.example.com:8200
+	Address string
+	// Path the auth method is mounted on, auth/<method> is used when it's empty
+	MountPath string
+	// Vault namespace the login request is sent to
+	Namespace string
+	// Role of the kubernetes and jwt methods, role id of the approle method
+	Role string
+	// File holding the credential of the kubernetes (service account token) and approle (secret id) methods
+	CredentialPath string
+	// The secret id of the approle method is a response wrapping token
+	SecretIdWrapped bool
+	// Pattern the creation path of the wrapping token must match, auth/<mount>/role/*/secret-id by default
+	WrappedCreationPath string
+	// Projected service account token of the jwt method
+	JwtPath string
+	// Role of the jwt method, Role is used when it's empty
+	JwtRole string
+	// Certificate role of the cert method, vault tries every role when it's empty
+	CertRole string
+	// The vault client was configured with a client certificate, required by the cert method
+	ClientCertificate bool
+	// Token of the token method
+	Token string
+	// File holding the token of the token method, ex. the sink of vault agent
+	TokenFile string
+	// Returns a vault client configured with the tls settings
+	Client func() (*apis.Client, error)
+}
+
+// Factory builds an auth method from the config
+type Factory func(method string, cfg *Config) (AuthMethod, error)
+
+var (
+	mu      sync.RWMutex
+	methods = make(map[string]Factory)
+)
+
+// Register makes an auth method available under the given name
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := methods[name]; ok {
+		panic(fmt.Sprintf("auth method %s is already registered", name))
+	}
+	methods[name] = factory
+}
+
+// Methods returns the names of the registered auth methods
+func Methods() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the auth method registered under the name
+func New(name string, cfg *Config) (AuthMethod, error) {
+	mu.RLock()
+	factory, ok := methods[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no matching auth method found for %q, use one of: %s",
+			name, strings.Join(Methods(), ", "))
+	}
+	if cfg.Client == nil {
+		cfg.Client = func() (*apis.Client, error) { return &apis.Client{}, nil }
+	}
+	return factory(name, cfg)
+}
+
+// Method returns the name of the auth method to use
+// An explicit method always wins, otherwise it's taken from the legacy auth/<method> mount path
+func Method(method, mountPath string) (string, error) {
+	if method != "" {
+		return method, nil
+	}
+	segments := strings.Split(strings.Trim(mountPath, "/"), "/")
+	if len(segments) != 2 || segments[0] != "auth" || segments[1] == "" {
+		return "", fmt.Errorf("cannot tell the auth method from the path %q, set VAULT_AUTH_METHOD", mountPath)
+	}
+	return segments[1], nil
+}
+
+// Return the login url of the method, the default mount path is auth/<method>
+func loginUrl(method string, cfg *Config) string {
+	mount := strings.Trim(cfg.MountPath, "/")
+	if mount == "" {
+		mount = "auth/" + method
+	}
+	return fmt.Sprintf("%s/v1/%s/login", strings.TrimRight(cfg.Address, "/"), mount)
+}
+
+// Send the login request and turn the auth block of the response into a Token
+func login(ctx context.Context, cfg *Config, url string, body []byte) (Token, error) {
+	if err := ctx.Err(); err != nil {
+		return Token{}, err
+	}
+	client, err := cfg.Client()
+	if err != nil {
+		return Token{}, err
+	}
+	payload, err := client.Login(ctx, body, url, cfg.Namespace)
+	if err != nil {
+		return Token{}, fmt.Errorf("error encountered while authenticating to vault: %s", err)
+	}
+	return tokenOf(payload), nil
+}
+
+// Turn the auth block of a vault payload into a Token
+func tokenOf(payload *models.Payload) Token {
+	return Token{
+		ClientToken: payload.Auth.ClientToken,
+		Accessor:    payload.Auth.Accessor,
+		Policies:    payload.Auth.Policies,
+		TTL:         time.Duration(payload.Auth.LeaseDuration) * time.Second,
+		Renewable:   payload.Auth.Renewable,
+	}
+}
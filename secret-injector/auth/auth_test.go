@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMethod(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		mountPath string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "legacy-path",
+			mountPath: "/auth/kubernetes/",
+			want:      "kubernetes",
+		},
+		{
+			name:      "explicit-method-custom-mount",
+			method:    "kubernetes",
+			mountPath: "auth/k8s/prod-cluster",
+			want:      "kubernetes",
+		},
+		{
+			name:      "custom-mount-without-method",
+			mountPath: "auth/k8s/prod-cluster",
+			wantErr:   true,
+		},
+		{
+			name:    "nothing-set",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Method(tt.method, tt.mountPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Method() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Method() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"approle", "cert", "jwt", "kubernetes", "token"} {
+		found := false
+		for _, registered := range Methods() {
+			if registered == name {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("auth method %s is not registered", name)
+		}
+	}
+	if _, err := New("ldap", &Config{}); err == nil {
+		t.Error("New() expected an error for an unknown method")
+	}
+	if _, err := New("cert", &Config{}); err == nil {
+		t.Error("New() expected an error for cert without a client certificate")
+	}
+}
+
+func TestKubernetes_Login(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	credential := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(credential, []byte("eyjt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/k8s/prod-cluster/login" {
+			t.Errorf("Login path is incorrect %s:", r.URL.Path)
+		}
+		var reqBody map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			t.Error("Unexpected body in request")
+		}
+		if reqBody["jwt"] != "eyjt" || reqBody["role"] != "sre" {
+			t.Errorf("Missing or incorrect jwt %s and role %s", reqBody["jwt"], reqBody["role"])
+		}
+		fmt.Fprintln(w, `{"auth":{"client_token": "token", "accessor": "acc", "policies": ["default", "sre"], "lease_duration": 900, "renewable": true}}`)
+	}))
+	defer server.Close()
+
+	method, err := New("kubernetes", &Config{
+		Address:        server.URL,
+		MountPath:      "auth/k8s/prod-cluster",
+		Role:           "sre",
+		CredentialPath: credential,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := method.Login(context.Background())
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	want := Token{
+		ClientToken: "token",
+		Accessor:    "acc",
+		Policies:    []string{"default", "sre"},
+		TTL:         900 * time.Second,
+		Renewable:   true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Login() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestToken_Login(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sink := filepath.Join(dir, "sink")
+	if err := ioutil.WriteFile(sink, []byte("s.agent\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/token/lookup-self" {
+			t.Errorf("Lookup path is incorrect %s:", r.URL.Path)
+		}
+		if r.Header.Get("X-Vault-Token") == "s.expired" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		fmt.Fprintln(w, `{"data": {"accessor": "acc", "policies": ["default"], "ttl": 3600, "renewable": true}}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		cfg     *Config
+		want    Token
+		wantErr bool
+	}{
+		{
+			name: "env-token",
+			cfg:  &Config{Address: server.URL, Token: "s.env"},
+			want: Token{ClientToken: "s.env", Accessor: "acc", Policies: []string{"default"}, TTL: time.Hour, Renewable: true},
+		},
+		{
+			name: "token-file",
+			cfg:  &Config{Address: server.URL, TokenFile: sink},
+			want: Token{ClientToken: "s.agent", Accessor: "acc", Policies: []string{"default"}, TTL: time.Hour, Renewable: true},
+		},
+		{
+			name:    "rejected-token",
+			cfg:     &Config{Address: server.URL, Token: "s.expired"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := New("token", tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := method.Login(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Login() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Login() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppRole_LoginWrapped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name          string
+		wrappingToken string
+		wantErr       bool
+	}{
+		{
+			name:          "valid",
+			wrappingToken: "s.wrapped",
+			wantErr:       false,
+		},
+		{
+			name:          "already-unwrapped",
+			wrappingToken: "s.used",
+			wantErr:       true,
+		},
+		{
+			name:          "wrong-creation-path",
+			wrappingToken: "s.other",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credential := filepath.Join(dir, tt.name)
+			if err := ioutil.WriteFile(credential, []byte(tt.wrappingToken+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			unwrapped := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/sys/wrapping/lookup":
+					var reqBody map[string]string
+					if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+						t.Error("Unexpected body in request")
+					}
+					switch reqBody["token"] {
+					case "s.wrapped":
+						fmt.Fprintln(w, `{"data": {"creation_path": "auth/approle/role/sre/secret-id", "creation_ttl": 60}}`)
+					case "s.other":
+						fmt.Fprintln(w, `{"data": {"creation_path": "secret/data/sre", "creation_ttl": 60}}`)
+					default:
+						w.WriteHeader(http.StatusBadRequest)
+						fmt.Fprintln(w, `{"errors": ["wrapping token is not valid or does not exist"]}`)
+					}
+				case "/v1/sys/wrapping/unwrap":
+					unwrapped = true
+					if r.Header.Get("X-Vault-Token") != "s.wrapped" {
+						t.Errorf("Wrapping token is incorrect %s:", r.Header.Get("X-Vault-Token"))
+					}
+					fmt.Fprintln(w, `{"data": {"secret_id": "secret-id", "secret_id_accessor": "acc"}}`)
+				case "/v1/auth/approle/login":
+					var reqBody map[string]string
+					if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+						t.Error("Unexpected body in request")
+					}
+					if reqBody["secret_id"] != "secret-id" || reqBody["role_id"] != "role-id" {
+						t.Errorf("Missing or incorrect secret_id %s and role_id %s", reqBody["secret_id"], reqBody["role_id"])
+					}
+					fmt.Fprintln(w, `{"auth":{"client_token": "token"}}`)
+				default:
+					t.Errorf("Unexpected request %s", r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			method, err := New("approle", &Config{
+				Address:         server.URL,
+				Role:            "role-id",
+				CredentialPath:  credential,
+				SecretIdWrapped: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := method.Login(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Login() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && unwrapped {
+				t.Error("Login() unwrapped a token it should have rejected")
+			}
+			if !tt.wantErr && got.ClientToken != "token" {
+				t.Errorf("Login() got = %v, want token", got.ClientToken)
+			}
+		})
+	}
+}
+
+func TestAppRole_LoginWrappedAgain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Vault lets a wrapping token be looked up and unwrapped only once
+	used := map[string]bool{}
+	secretIds := map[string]string{"s.first": "secret-id-1", "s.second": "secret-id-2"}
+	unwraps := 0
+	var loggedIn []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/lookup":
+			var reqBody map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+				t.Error("Unexpected body in request")
+			}
+			if used[reqBody["token"]] {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintln(w, `{"errors": ["wrapping token is not valid or does not exist"]}`)
+				return
+			}
+			fmt.Fprintln(w, `{"data": {"creation_path": "auth/approle/role/sre/secret-id", "creation_ttl": 60}}`)
+		case "/v1/sys/wrapping/unwrap":
+			token := r.Header.Get("X-Vault-Token")
+			used[token] = true
+			unwraps++
+			fmt.Fprintf(w, `{"data": {"secret_id": "%s"}}`, secretIds[token])
+		case "/v1/auth/approle/login":
+			var reqBody map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+				t.Error("Unexpected body in request")
+			}
+			loggedIn = append(loggedIn, reqBody["secret_id"])
+			fmt.Fprintln(w, `{"auth":{"client_token": "token"}}`)
+		default:
+			t.Errorf("Unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	credential := filepath.Join(dir, "secret-id")
+	if err := ioutil.WriteFile(credential, []byte("s.first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	method, err := New("approle", &Config{
+		Address:         server.URL,
+		Role:            "role-id",
+		CredentialPath:  credential,
+		SecretIdWrapped: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := method.Login(context.Background()); err != nil {
+			t.Fatalf("Login() %d error = %v", i+1, err)
+		}
+	}
+	if unwraps != 1 {
+		t.Errorf("Login() unwrapped %d times, want 1", unwraps)
+	}
+
+	// Another wrapping token is mounted, it's unwrapped for the next login
+	if err := ioutil.WriteFile(credential, []byte("s.second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := method.Login(context.Background()); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if unwraps != 2 {
+		t.Errorf("Login() unwrapped %d times, want 2", unwraps)
+	}
+	want := []string{"secret-id-1", "secret-id-1", "secret-id-2"}
+	if !reflect.DeepEqual(loggedIn, want) {
+		t.Errorf("Login() secret ids = %v, want %v", loggedIn, want)
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+func init() {
+	Register("cert", newCert)
+}
+
+// cert logs in with the client certificate of the tls connection
+type cert struct {
+	cfg *Config
+	url string
+}
+
+func newCert(method string, cfg *Config) (AuthMethod, error) {
+	if !cfg.ClientCertificate {
+		return nil, fmt.Errorf("auth method %s needs VAULT_CLIENT_CERT and VAULT_CLIENT_KEY", method)
+	}
+	return &cert{cfg: cfg, url: loginUrl(method, cfg)}, nil
+}
+
+func (c *cert) Name() string {
+	return "cert"
+}
+
+func (c *cert) Login(ctx context.Context) (Token, error) {
+	// The client certificate is the credential, the name only picks the role
+	body := map[string]string{}
+	if c.cfg.CertRole != "" {
+		body["name"] = c.cfg.CertRole
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to construct json payload for auth method: cert")
+	}
+	return login(ctx, c.cfg, c.url, data)
+}
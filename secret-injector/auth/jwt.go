@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/log"
+)
+
+var logger = log.NewLogger()
+
+func init() {
+	Register("jwt", newJwt)
+}
+
+// jwt logs in with a bound service account token projected with the audience of vault
+// Unlike the kubernetes method there is no need for a long-lived reviewer token
+type jwt struct {
+	cfg   *Config
+	url   string
+	token *projectedToken
+}
+
+func newJwt(method string, cfg *Config) (AuthMethod, error) {
+	if cfg.JwtPath == "" {
+		return nil, fmt.Errorf("auth method %s needs the path of the projected token", method)
+	}
+	return &jwt{
+		cfg:   cfg,
+		url:   loginUrl(method, cfg),
+		token: &projectedToken{path: cfg.JwtPath},
+	}, nil
+}
+
+func (j *jwt) Name() string {
+	return "jwt"
+}
+
+func (j *jwt) Login(ctx context.Context) (Token, error) {
+	// Read the token on every login, kubelet may have rotated it in between
+	token, err := j.token.Read()
+	if err != nil {
+		return Token{}, err
+	}
+	role := j.cfg.JwtRole
+	if role == "" {
+		role = j.cfg.Role
+	}
+	body, err := json.Marshal(map[string]string{
+		"jwt":  token,
+		"role": role,
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to construct json payload for auth method: jwt")
+	}
+	return login(ctx, j.cfg, j.url, body)
+}
+
+// projectedToken reads a token that kubelet keeps rotating on disk
+// The file is read again only when it has changed since the last read
+type projectedToken struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	token   string
+}
+
+// Read return the current token, re-reading the file after kubelet has rotated it
+func (p *projectedToken) Read() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Stat follows the ..data symlink kubelet swaps on rotation so the time changes with the token
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read projected token error: %v", err)
+	}
+	if p.token != "" && info.ModTime().Equal(p.modTime) {
+		return p.token, nil
+	}
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read projected token error: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("projected token %s is empty", p.path)
+	}
+	if p.token != "" && token != p.token {
+		logger.Infof("projected token %s has been rotated", p.path)
+	}
+	p.token = token
+	p.modTime = info.ModTime()
+	return p.token, nil
+}
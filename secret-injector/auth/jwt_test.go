@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_projectedToken_Read(t *testing.T) {
+	dir, err := ioutil.TempDir("", "projected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := &projectedToken{path: path}
+
+	got, err := p.Read()
+	if err != nil || got != "first" {
+		t.Fatalf("Read() = %v, %v, want first", got, err)
+	}
+
+	// kubelet rotates the token, the next read must pick it up
+	if err := ioutil.WriteFile(path, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Read()
+	if err != nil || got != "second" {
+		t.Fatalf("Read() = %v, %v, want second", got, err)
+	}
+
+	// A missing token is an error
+	os.Remove(path)
+	if _, err := p.Read(); err == nil {
+		t.Error("Read() expected an error for a missing token")
+	}
+}
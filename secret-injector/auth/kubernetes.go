@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+func init() {
+	Register("kubernetes", newKubernetes)
+}
+
+// kubernetes logs in with the token of the vault auth service account
+type kubernetes struct {
+	cfg *Config
+	url string
+}
+
+func newKubernetes(method string, cfg *Config) (AuthMethod, error) {
+	if cfg.CredentialPath == "" {
+		return nil, fmt.Errorf("auth method %s needs the path of the service account token", method)
+	}
+	return &kubernetes{cfg: cfg, url: loginUrl(method, cfg)}, nil
+}
+
+func (k *kubernetes) Name() string {
+	return "kubernetes"
+}
+
+func (k *kubernetes) Login(ctx context.Context) (Token, error) {
+	// Read the mounted token so we can use it by adding it the vault-token header in http request
+	vaultToken, err := ioutil.ReadFile(k.cfg.CredentialPath)
+	if err != nil {
+		return Token{}, fmt.Errorf("cannot read vault token error %v", err)
+	}
+	body, err := json.Marshal(map[string]string{
+		"jwt":  string(vaultToken),
+		"role": k.cfg.Role,
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to construct json payload for auth method: kubernetes")
+	}
+	return login(ctx, k.cfg, k.url, body)
+}
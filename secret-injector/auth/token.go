@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("token", newToken)
+}
+
+// token skips the login and uses a token that was handed over, either in VAULT_TOKEN
+// or in a file such as the sink of vault agent
+type token struct {
+	cfg  *Config
+	file *projectedToken
+}
+
+func newToken(method string, cfg *Config) (AuthMethod, error) {
+	t := &token{cfg: cfg}
+	if cfg.Token == "" {
+		if cfg.TokenFile == "" {
+			return nil, fmt.Errorf("auth method %s needs VAULT_TOKEN or VAULT_TOKEN_FILE", method)
+		}
+		// The sink is rewritten by vault agent, read it the same way as a projected token
+		t.file = &projectedToken{path: cfg.TokenFile}
+	}
+	return t, nil
+}
+
+func (t *token) Name() string {
+	return "token"
+}
+
+func (t *token) Login(ctx context.Context) (Token, error) {
+	if err := ctx.Err(); err != nil {
+		return Token{}, err
+	}
+	clientToken := t.cfg.Token
+	if t.file != nil {
+		var err error
+		if clientToken, err = t.file.Read(); err != nil {
+			return Token{}, err
+		}
+	}
+
+	// Make sure the token is still valid before we start to sync
+	client, err := t.cfg.Client()
+	if err != nil {
+		return Token{}, err
+	}
+	url := fmt.Sprintf("%s/v1/auth/token/lookup-self", strings.TrimRight(t.cfg.Address, "/"))
+	info, err := client.LookupSelf(ctx, clientToken, url, t.cfg.Namespace)
+	if err != nil {
+		return Token{}, fmt.Errorf("error encountered while looking up vault token: %s", err)
+	}
+
+	ttl := time.Duration(info.Data.TTL) * time.Second
+	// Never log the token itself, the accessor is enough to find it in the audit log
+	if ttl == 0 {
+		logger.Infof("using vault token with accessor %s, policies %v and no expiry",
+			info.Data.Accessor, info.Data.Policies)
+	} else {
+		logger.Infof("using vault token with accessor %s, policies %v and ttl %s",
+			info.Data.Accessor, info.Data.Policies, ttl)
+	}
+
+	return Token{
+		ClientToken: clientToken,
+		Accessor:    info.Data.Accessor,
+		Policies:    info.Data.Policies,
+		TTL:         ttl,
+		Renewable:   info.Data.Renewable,
+	}, nil
+}
@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+const (
+	// LeaseAnnotation holds the lease of the dynamic credentials written in the secret
+	LeaseAnnotation = "vault-gopher.io/lease"
+
+	// Formats of the aws credentials
+	AWSFormatEnv  = "env"
+	AWSFormatFile = "file"
+)
+
+// lease is what we remember of the lease of dynamic credentials, as the json of LeaseAnnotation
+type lease struct {
+	Id        string    `json:"id"`
+	Duration  int       `json:"duration"`
+	Renewable bool      `json:"renewable"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Return the job that issues the credentials of an aws secret
+// The lease of the credentials in kubernetes is renewed while it can be, they are issued again when it can't
+func (s *Syncer) awsJob(ctx context.Context, secret *secretJob, cluster *models.Cluster, clientToken, objectName string) func() error {
+	spec := secret.spec.AWS
+	return func() error {
+		if err := validateAWS(secret.name, secret.spec); err != nil {
+			return failure(ReasonVaultReadFailed, err)
+		}
+		var renewBefore time.Duration
+		if spec.RenewBefore != "" {
+			d, err := time.ParseDuration(spec.RenewBefore)
+			if err != nil {
+				return failure(ReasonVaultReadFailed, fmt.Errorf("invalid renewBefore of aws secret %s: %s", secret.name, err))
+			}
+			renewBefore = d
+		}
+
+		client, err := s.vaultClient()
+		if err != nil {
+			return failure(ReasonVaultReadFailed, err)
+		}
+		namespace := s.pathNamespace(secret.spec, models.PathSpec{})
+		now := time.Now()
+
+		current, data := s.appliedLease(ctx, secret, cluster, objectName)
+		if current != nil {
+			if current.Expires.Sub(now) > leaseRenewBefore(current, renewBefore) {
+				secret.upToDate = true
+				return nil
+			}
+			// The credentials stay the same when the lease is renewed, only the annotation changes
+			if current.Renewable && data != nil {
+				renewed, err := s.renewLease(ctx, client, clientToken, namespace, current, now)
+				if err != nil {
+					logger.Warnf("cannot renew the lease of aws secret %s, issuing new credentials: %s", secret.name, err)
+				} else if renewed.Expires.Sub(now) > leaseRenewBefore(renewed, renewBefore) {
+					secret.payloads = []map[string]interface{}{data}
+					secret.annotations = leaseAnnotations(renewed)
+					return nil
+				}
+			}
+		}
+
+		mount := spec.Mount
+		if mount == "" {
+			mount = s.opts.AWSPath
+		}
+		endpoint := spec.Endpoint
+		if endpoint == "" {
+			endpoint = "creds"
+		}
+		body := make(map[string]interface{})
+		if spec.RoleArn != "" {
+			body["role_arn"] = spec.RoleArn
+		}
+		if spec.TTL != "" {
+			body["ttl"] = spec.TTL
+		}
+		awsUrl := &RequestUrl{BaseUrl: s.opts.VaultAddress, Path: mount}
+		payload, err := client.ReadSecret(ctx, clientToken, awsUrl.GetPath(endpoint+"/"+spec.Role), namespace, body)
+		if err != nil {
+			return failure(ReasonVaultReadFailed, fmt.Errorf("cannot issue the credentials of aws secret %s: %s", secret.name, err))
+		}
+		issued := &lease{
+			Id:        payload.LeaseId,
+			Duration:  payload.LeaseDuration,
+			Renewable: payload.Renewable,
+			Expires:   now.Add(time.Duration(payload.LeaseDuration) * time.Second).UTC().Truncate(time.Second),
+		}
+		data, err = awsCredentials(spec, payload.Data)
+		if err != nil {
+			return failure(ReasonVaultReadFailed, fmt.Errorf("cannot render the credentials of aws secret %s: %s", secret.name, err))
+		}
+		secret.payloads = []map[string]interface{}{data}
+		secret.annotations = leaseAnnotations(issued)
+		return nil
+	}
+}
+
+func validateAWS(name string, spec *models.SecretSpec) error {
+	if len(spec.Paths) != 0 || spec.Directory != nil || spec.SSH != nil {
+		return fmt.Errorf("aws secret %s can't have paths as well", name)
+	}
+	if spec.AWS.Role == "" {
+		return fmt.Errorf("aws secret %s has no role", name)
+	}
+	switch spec.AWS.Endpoint {
+	case "", "creds", "sts":
+	default:
+		return fmt.Errorf("endpoint of aws secret %s should be creds or sts", name)
+	}
+	switch spec.AWS.Format {
+	case "", AWSFormatEnv, AWSFormatFile:
+	default:
+		return fmt.Errorf("format of aws secret %s should be %s or %s", name, AWSFormatEnv, AWSFormatFile)
+	}
+	return nil
+}
+
+// Return the time before the expiry the credentials are renewed, a third of the lease by default
+func leaseRenewBefore(l *lease, renewBefore time.Duration) time.Duration {
+	if renewBefore != 0 {
+		return renewBefore
+	}
+	return time.Duration(l.Duration) * time.Second / 3
+}
+
+// Renew the lease for the same duration it was issued for
+func (s *Syncer) renewLease(ctx context.Context, client Vault, clientToken, namespace string, current *lease, now time.Time) (*lease, error) {
+	renewUrl := &RequestUrl{BaseUrl: s.opts.VaultAddress, Path: "sys/leases"}
+	payload, err := client.RenewLease(ctx, clientToken, renewUrl.GetPath("renew"), namespace, current.Id, current.Duration)
+	if err != nil {
+		return nil, err
+	}
+	return &lease{
+		Id:        current.Id,
+		Duration:  current.Duration,
+		Renewable: payload.Renewable,
+		// Vault gives less than what we asked for once the lease gets close to its max ttl
+		Expires: now.Add(time.Duration(payload.LeaseDuration) * time.Second).UTC().Truncate(time.Second),
+	}, nil
+}
+
+// Return the lease shared by every copy of the secret and the data of one of them
+// nil is returned when a copy is missing or holds a different lease, the credentials are issued again
+func (s *Syncer) appliedLease(ctx context.Context, secret *secretJob, cluster *models.Cluster, objectName string) (*lease, map[string]interface{}) {
+	client := s.kubernetesClient()
+	var ret *lease
+	var data map[string]interface{}
+	for _, t := range secret.targets {
+		if t.err != nil {
+			continue
+		}
+		status, live, err := client.GetSecret(ctx, cluster, t.namespace, objectName, secret.name)
+		if err != nil || status != 200 {
+			return nil, nil
+		}
+		meta, _ := live["metadata"].(map[string]interface{})
+		annotations, _ := meta["annotations"].(map[string]interface{})
+		value, _ := annotations[LeaseAnnotation].(string)
+		var l lease
+		if err := json.Unmarshal([]byte(value), &l); err != nil || l.Expires.IsZero() {
+			return nil, nil
+		}
+		if ret != nil && ret.Id != l.Id {
+			return nil, nil
+		}
+		ret = &l
+		if data == nil {
+			data = decodeData(live)
+		}
+	}
+	return ret, data
+}
+
+// Return the base64 decoded data of a live secret
+func decodeData(live map[string]interface{}) map[string]interface{} {
+	encoded, _ := live["data"].(map[string]interface{})
+	ret := make(map[string]interface{}, len(encoded))
+	for key, value := range encoded {
+		s, _ := value.(string)
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil
+		}
+		ret[key] = string(decoded)
+	}
+	return ret
+}
+
+func leaseAnnotations(l *lease) map[string]interface{} {
+	value, err := json.Marshal(l)
+	if err != nil {
+		return nil
+	}
+	return map[string]interface{}{LeaseAnnotation: string(value)}
+}
+
+// Render the credentials vault returned in the format of the spec
+// iam_user credentials have no session token, the key is left out
+func awsCredentials(spec *models.AWSSpec, data map[string]interface{}) (map[string]interface{}, error) {
+	accessKey, _ := data["access_key"].(string)
+	secretKey, _ := data["secret_key"].(string)
+	sessionToken, _ := data["security_token"].(string)
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("no access_key or secret_key found in the payload")
+	}
+
+	if spec.Format == AWSFormatFile {
+		profile := spec.Profile
+		if profile == "" {
+			profile = "default"
+		}
+		key := spec.FileKey
+		if key == "" {
+			key = "credentials"
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "[%s]\n", profile)
+		fmt.Fprintf(&b, "aws_access_key_id = %s\n", accessKey)
+		fmt.Fprintf(&b, "aws_secret_access_key = %s\n", secretKey)
+		if sessionToken != "" {
+			fmt.Fprintf(&b, "aws_session_token = %s\n", sessionToken)
+		}
+		return map[string]interface{}{key: b.String()}, nil
+	}
+
+	ret := map[string]interface{}{
+		"AWS_ACCESS_KEY_ID":     accessKey,
+		"AWS_SECRET_ACCESS_KEY": secretKey,
+	}
+	if sessionToken != "" {
+		ret["AWS_SESSION_TOKEN"] = sessionToken
+	}
+	return ret, nil
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func Test_syncSecrets_aws(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	issued := 0
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/v1/aws/creds/deploy":
+			issued++
+			fmt.Fprintf(w, `{"lease_id": "aws/creds/deploy/%d", "lease_duration": 3600, "renewable": true,
+				"data": {"access_key": "AKIA%d", "secret_key": "secret%d", "security_token": null}}`, issued, issued, issued)
+		case "/v1/aws/sts/deploy":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["ttl"] != "15m" {
+				t.Errorf("ttl is incorrect: %v", body["ttl"])
+			}
+			fmt.Fprintln(w, `{"lease_id": "", "lease_duration": 900, "renewable": false,
+				"data": {"access_key": "ASIA1", "secret_key": "secret", "security_token": "session"}}`)
+		case "/v1/sys/leases/renew":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			fmt.Fprintf(w, `{"lease_id": %q, "lease_duration": 7200, "renewable": true}`, body["lease_id"])
+		default:
+			t.Errorf("url is incorrect: %s", r.URL.Path)
+		}
+	}))
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+
+	s := NewSyncer(kube.options(vault.URL))
+
+	tests := []struct {
+		name         string
+		secret       string
+		spec         *models.AWSSpec
+		wantRequests []string
+		wantWrites   int
+		wantData     map[string]string
+		wantLease    string
+	}{
+		{
+			name:         "issue",
+			secret:       "deploy-sit-secret",
+			spec:         &models.AWSSpec{Role: "deploy"},
+			wantRequests: []string{"GET /v1/aws/creds/deploy"},
+			wantWrites:   1,
+			wantData:     map[string]string{"AWS_ACCESS_KEY_ID": "AKIA1", "AWS_SECRET_ACCESS_KEY": "secret1"},
+			wantLease:    "aws/creds/deploy/1",
+		},
+		{
+			name:       "lease-valid",
+			secret:     "deploy-sit-secret",
+			spec:       &models.AWSSpec{Role: "deploy"},
+			wantWrites: 0,
+			wantData:   map[string]string{"AWS_ACCESS_KEY_ID": "AKIA1", "AWS_SECRET_ACCESS_KEY": "secret1"},
+			wantLease:  "aws/creds/deploy/1",
+		},
+		{
+			name:         "renew",
+			secret:       "deploy-sit-secret",
+			spec:         &models.AWSSpec{Role: "deploy", RenewBefore: "90m"},
+			wantRequests: []string{"PUT /v1/sys/leases/renew"},
+			wantWrites:   1,
+			wantData:     map[string]string{"AWS_ACCESS_KEY_ID": "AKIA1", "AWS_SECRET_ACCESS_KEY": "secret1"},
+			wantLease:    "aws/creds/deploy/1",
+		},
+		{
+			name:         "max-ttl-reached",
+			secret:       "deploy-sit-secret",
+			spec:         &models.AWSSpec{Role: "deploy", RenewBefore: "3h"},
+			wantRequests: []string{"PUT /v1/sys/leases/renew", "GET /v1/aws/creds/deploy"},
+			wantWrites:   1,
+			wantData:     map[string]string{"AWS_ACCESS_KEY_ID": "AKIA2", "AWS_SECRET_ACCESS_KEY": "secret2"},
+			wantLease:    "aws/creds/deploy/2",
+		},
+		{
+			name:         "sts-file",
+			secret:       "batch-sit-secret",
+			spec:         &models.AWSSpec{Role: "deploy", Endpoint: "sts", TTL: "15m", Format: AWSFormatFile, Profile: "batch"},
+			wantRequests: []string{"POST /v1/aws/sts/deploy"},
+			wantWrites:   1,
+			wantData: map[string]string{
+				"credentials": "[batch]\naws_access_key_id = ASIA1\naws_secret_access_key = secret\naws_session_token = session\n",
+			},
+		},
+		{
+			name:       "sts-valid",
+			secret:     "batch-sit-secret",
+			spec:       &models.AWSSpec{Role: "deploy", Endpoint: "sts", TTL: "15m", Format: AWSFormatFile, Profile: "batch"},
+			wantWrites: 0,
+			wantData: map[string]string{
+				"credentials": "[batch]\naws_access_key_id = ASIA1\naws_secret_access_key = secret\naws_session_token = session\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			requests = nil
+			mu.Unlock()
+			kube.reset()
+
+			vars := map[string]*models.SecretSpec{tt.secret: {AWS: tt.spec}}
+			if _, err := s.syncSecrets(context.Background(), vars, "token", nil, false); err != nil {
+				t.Fatalf("syncSecrets() error = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(requests, tt.wantRequests) {
+				t.Errorf("syncSecrets() requests = %v, want %v", requests, tt.wantRequests)
+			}
+			if writes := kube.writes(); writes != tt.wantWrites {
+				t.Errorf("syncSecrets() writes = %v, want %v", writes, tt.wantWrites)
+			}
+
+			object := kube.object("sit-sre", tt.secret)
+			data := make(map[string]string)
+			for key, value := range object["data"].(map[string]interface{}) {
+				decoded, _ := base64.StdEncoding.DecodeString(value.(string))
+				data[key] = string(decoded)
+			}
+			if !reflect.DeepEqual(data, tt.wantData) {
+				t.Errorf("syncSecrets() data = %v, want %v", data, tt.wantData)
+			}
+			annotations := object["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+			var got lease
+			if err := json.Unmarshal([]byte(annotations[LeaseAnnotation].(string)), &got); err != nil {
+				t.Fatalf("lease annotation is invalid: %s", err)
+			}
+			if got.Id != tt.wantLease {
+				t.Errorf("syncSecrets() lease = %v, want %v", got.Id, tt.wantLease)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"github.com/trx35479/vault-gopher/secret-injector/worker"
+)
+
+// Length of a generated value when the generator doesn't set one
+const defaultGeneratedLength = 32
+
+// Named character sets of the generators
+var charsets = map[string]string{
+	"alphanumeric": "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"alpha":        "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"numeric":      "0123456789",
+	"hex":          "0123456789abcdef",
+	"symbols":      "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&()*+,-./:;<=>?@[]^_{|}~",
+}
+
+// bootstrapWrite is the missing keys of a secret that are written to the same vault path
+type bootstrapWrite struct {
+	path models.PathSpec
+	keys []string
+	// Values of the keys once they are in vault, generated by us or written by someone else in between
+	values map[string]interface{}
+}
+
+// Generate the missing keys of the secrets and write them to vault before they are synced
+// A value that is in vault is never overwritten, the write uses check-and-set so a write made in between wins
+func (s *Syncer) bootstrap(ctx context.Context, secrets []*secretJob, clientToken string, pool *worker.Pool, limiter *worker.Limiter) {
+	dataUrl := &RequestUrl{
+		BaseUrl: s.opts.VaultAddress,
+		Path:    s.opts.SecretPath,
+	}
+
+	var jobs []worker.Job
+	var owners []*secretJob
+	var done []*bootstrapWrite
+	for _, secret := range secrets {
+		if secret.err != nil || secret.upToDate || len(secret.spec.Generate) == 0 {
+			continue
+		}
+		var missing []string
+		for key := range secret.spec.Generate {
+			if _, ok := secret.data[key]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		sort.Strings(missing)
+		if !s.opts.Bootstrap {
+			logger.Warnf("secret %s is missing %s, set BOOTSTRAP_SECRETS=true to generate them",
+				secret.name, strings.Join(missing, ", "))
+			continue
+		}
+		if s.opts.DryRun {
+			logger.Warnf("secret %s is missing %s, they are generated by the sync", secret.name, strings.Join(missing, ", "))
+			continue
+		}
+
+		writes, err := bootstrapWrites(secret.spec, missing)
+		if err != nil {
+			secret.err = failure(ReasonBootstrapFailed, fmt.Errorf("cannot bootstrap secret %s: %s", secret.name, err))
+			continue
+		}
+		for _, write := range writes {
+			secret, write := secret, write
+			url := dataUrl.GetPath(write.path.Path)
+			namespace := s.pathNamespace(secret.spec, write.path)
+			jobs = append(jobs, func() error {
+				client, err := s.vaultClient()
+				if err != nil {
+					return failure(ReasonBootstrapFailed, err)
+				}
+				limiter.Wait()
+				data, version, err := client.ReadKV(ctx, clientToken, url, namespace)
+				if err != nil {
+					return failure(ReasonBootstrapFailed, fmt.Errorf("cannot read %s to bootstrap it: %s", write.path.Path, err))
+				}
+				if data == nil {
+					data = make(map[string]interface{})
+				}
+
+				values := make(map[string]interface{})
+				var generated []string
+				for _, key := range write.keys {
+					// Someone wrote the key since we fetched the secret, it's kept as it is
+					if value, ok := data[key]; ok {
+						values[key] = value
+						continue
+					}
+					limiter.Wait()
+					value, err := s.generateValue(ctx, client, clientToken, namespace, secret.spec.Generate[key])
+					if err != nil {
+						return failure(ReasonBootstrapFailed, fmt.Errorf("cannot generate %s of %s: %s", key, secret.name, err))
+					}
+					data[key] = value
+					values[key] = value
+					generated = append(generated, key)
+				}
+
+				if len(generated) != 0 {
+					limiter.Wait()
+					if err := client.WriteKV(ctx, clientToken, url, namespace, data, version); err != nil {
+						return failure(ReasonBootstrapFailed, fmt.Errorf("cannot write %s to %s: %s", strings.Join(generated, ", "), write.path.Path, err))
+					}
+					logger.Infof("generated %s of secret %s in %s", strings.Join(generated, ", "), secret.name, write.path.Path)
+				}
+				write.values = values
+				return nil
+			})
+			owners = append(owners, secret)
+			done = append(done, write)
+		}
+	}
+
+	for i, err := range pool.Run(jobs) {
+		if err != nil {
+			if err != worker.ErrSkipped && owners[i].err == nil {
+				owners[i].err = err
+			}
+			continue
+		}
+		// The writes of a secret share its data, the values are put in place once the pool is done
+		for key, value := range done[i].values {
+			owners[i].data[key] = value
+		}
+	}
+}
+
+// Group the missing keys by the vault path they are written to
+func bootstrapWrites(spec *models.SecretSpec, missing []string) ([]*bootstrapWrite, error) {
+	var ret []*bootstrapWrite
+	writes := make(map[models.PathSpec]*bootstrapWrite)
+	for _, key := range missing {
+		var path models.PathSpec
+		if gen := spec.Generate[key]; gen != nil && gen.Path != "" {
+			path = models.ParsePath(gen.Path)
+		} else if len(spec.Paths) != 0 {
+			path = spec.Paths[len(spec.Paths)-1]
+		} else {
+			return nil, fmt.Errorf("key %s has no vault path to be written to", key)
+		}
+		write, ok := writes[path]
+		if !ok {
+			write = &bootstrapWrite{path: path}
+			writes[path] = write
+			ret = append(ret, write)
+		}
+		write.keys = append(write.keys, key)
+	}
+	return ret, nil
+}
+
+// Generate a value with the password policy of vault or with the characters of the generator
+func (s *Syncer) generateValue(ctx context.Context, client Vault, clientToken, namespace string, gen *models.GeneratorSpec) (string, error) {
+	if gen == nil {
+		gen = &models.GeneratorSpec{}
+	}
+	if gen.Policy != "" {
+		policyUrl := &RequestUrl{BaseUrl: s.opts.VaultAddress, Path: "sys/policies/password"}
+		return client.GeneratePassword(ctx, clientToken, policyUrl.GetPath(gen.Policy+"/generate"), namespace)
+	}
+	return randomString(gen.Length, gen.Charset)
+}
+
+// Return a random string of the given length made of the characters of the charset
+func randomString(length int, charset string) (string, error) {
+	if length <= 0 {
+		length = defaultGeneratedLength
+	}
+	if charset == "" {
+		charset = "alphanumeric"
+	}
+	if named, ok := charsets[charset]; ok {
+		charset = named
+	}
+
+	chars := []rune(charset)
+	size := big.NewInt(int64(len(chars)))
+	ret := make([]rune, length)
+	for i := range ret {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		ret[i] = chars[n.Int64()]
+	}
+	return string(ret), nil
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// fakeKV is a kv v2 mount that enforces check-and-set
+type fakeKV struct {
+	mu       sync.Mutex
+	data     map[string]map[string]interface{}
+	versions map[string]int
+	writes   []string
+	// Someone else writes to the path between our read and our write
+	conflict bool
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/v1/sys/policies/password/strong/generate" {
+		fmt.Fprintln(w, `{"data": {"password": "from-policy"}}`)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+	if r.Method == http.MethodGet {
+		data, ok := f.data[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"errors": []}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": f.versions[path]}},
+		})
+		return
+	}
+	var body struct {
+		Options struct {
+			Cas int `json:"cas"`
+		} `json:"options"`
+		Data map[string]interface{} `json:"data"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if f.conflict || body.Options.Cas != f.versions[path] {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, `{"errors": ["check-and-set parameter did not match the current version"]}`)
+		return
+	}
+	f.versions[path]++
+	f.data[path] = body.Data
+	f.writes = append(f.writes, fmt.Sprintf("%s cas=%d", path, body.Options.Cas))
+	fmt.Fprintln(w, `{"data": {}}`)
+}
+
+func Test_syncSecrets_bootstrap(t *testing.T) {
+	kv := &fakeKV{
+		data:     map[string]map[string]interface{}{"app/db": {"username": "app"}},
+		versions: map[string]int{"app/db": 1},
+	}
+	vault := httptest.NewServer(kv)
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+
+	spec := &models.SecretSpec{
+		Paths: []models.PathSpec{{Path: "app/api"}, {Path: "app/db"}},
+		Generate: map[string]*models.GeneratorSpec{
+			"username": {},
+			"password": {Length: 16, Charset: "hex"},
+			"api-key":  {Policy: "strong", Path: "app/api"},
+			// A null generator takes the defaults
+			"token": nil,
+		},
+	}
+	tests := []struct {
+		name       string
+		enabled    bool
+		conflict   bool
+		wantWrites []string
+		wantKeys   []string
+		wantErr    string
+	}{
+		{
+			// Nothing creates app/api when bootstrap is off
+			name:    "disabled",
+			wantErr: "error while fetching secrets from vault",
+		},
+		{
+			name:       "generate",
+			enabled:    true,
+			wantWrites: []string{"app/api cas=0", "app/db cas=1"},
+			wantKeys:   []string{"api-key", "password", "token", "username"},
+		},
+		{
+			name:     "already-generated",
+			enabled:  true,
+			wantKeys: []string{"api-key", "password", "token", "username"},
+		},
+		{
+			name:     "conflict",
+			enabled:  true,
+			conflict: true,
+			wantErr:  "check-and-set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := kube.options(vault.URL)
+			opts.Bootstrap = tt.enabled
+			kv.mu.Lock()
+			kv.writes = nil
+			kv.conflict = tt.conflict
+			if tt.conflict {
+				delete(kv.data["app/db"], "password")
+			}
+			kv.mu.Unlock()
+
+			vars := map[string]*models.SecretSpec{"app-sit-secret": spec}
+			_, err := NewSyncer(opts).syncSecrets(context.Background(), vars, "token", nil, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("syncSecrets() error = %v, want %v", err, tt.wantErr)
+				}
+				if len(kv.writes) != 0 {
+					t.Errorf("syncSecrets() vault writes = %v, want none", kv.writes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("syncSecrets() error = %v", err)
+			}
+
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			writes := append([]string(nil), kv.writes...)
+			if fmt.Sprint(sortStrings(writes)) != fmt.Sprint(tt.wantWrites) {
+				t.Errorf("syncSecrets() vault writes = %v, want %v", kv.writes, tt.wantWrites)
+			}
+
+			data := kube.object("sit-sre", "app-sit-secret")["data"].(map[string]interface{})
+			var keys []string
+			for key, value := range data {
+				keys = append(keys, key)
+				decoded, _ := base64.StdEncoding.DecodeString(value.(string))
+				// What is synced is what is in vault
+				stored := kv.data["app/db"][key]
+				if stored == nil {
+					stored = kv.data["app/api"][key]
+				}
+				if string(decoded) != stored {
+					t.Errorf("syncSecrets() %s = %s, vault has %v", key, decoded, stored)
+				}
+			}
+			if !reflect.DeepEqual(sortStrings(keys), tt.wantKeys) {
+				t.Errorf("syncSecrets() keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if tt.enabled {
+				if password := kv.data["app/db"]["password"].(string); len(password) != 16 || strings.Trim(password, "0123456789abcdef") != "" {
+					t.Errorf("syncSecrets() password = %v, want 16 hex characters", password)
+				}
+				if kv.data["app/api"]["api-key"] != "from-policy" {
+					t.Errorf("syncSecrets() api-key = %v, want the value of the policy", kv.data["app/api"]["api-key"])
+				}
+			}
+		})
+	}
+}
+
+func sortStrings(s []string) []string {
+	sort.Strings(s)
+	return s
+}
@@ -17,15 +17,26 @@ var logger = log.NewLogger()
 
 // Https config for the client + the ca cert
 func (c *Client) Https(ca []byte) *http.Client {
-	caCertPool := x509.NewCertPool()
-	if ok := caCertPool.AppendCertsFromPEM(ca); !ok {
-		logger.Println("could not decode ca.cert")
+	return c.Mtls(ca, nil, false)
+}
+
+// Mtls config for the client + the ca cert and the client certificates
+// An empty ca falls back to the system pool, set the insecure to true to skip the verification of the server
+func (c *Client) Mtls(ca []byte, certs []tls.Certificate, insecure bool) *http.Client {
+	tlsConfig := &tls.Config{
+		Certificates:       certs,
+		InsecureSkipVerify: insecure,
+	}
+	if len(ca) != 0 {
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(ca); !ok {
+			logger.Println("could not decode ca.cert")
+		}
+		tlsConfig.RootCAs = caCertPool
 	}
 	c.HttpClient = &http.Client{
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
+			TLSClientConfig: tlsConfig,
 		},
 		Timeout: 2 * time.Second,
 	}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/trx35479/vault-gopher/secret-injector/apis"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// Vault is what the sync asks vault for, apis.Client implements it
+// A Vault given to a Syncer is shared by the workers and has to be safe for concurrent use
+type Vault interface {
+	GetStatus(ctx context.Context, address, path string) error
+	RevokeToken(ctx context.Context, vaultAddress, path, token, namespace string) (bool, error)
+	LookupSelf(ctx context.Context, token, url, namespace string) (*models.TokenInfo, error)
+	GetData(ctx context.Context, token, url, namespace string) (map[string]interface{}, error)
+	ReadKV(ctx context.Context, token, url, namespace string) (map[string]interface{}, int, error)
+	WriteKV(ctx context.Context, token, url, namespace string, data map[string]interface{}, cas int) error
+	List(ctx context.Context, token, url, namespace string) ([]string, error)
+	GetMetadata(ctx context.Context, token, url, namespace string) (*models.Version, error)
+	Decrypt(ctx context.Context, token, url, namespace string, ciphertexts []string) ([]string, error)
+	SignSSH(ctx context.Context, token, url, namespace string, request map[string]interface{}) (string, error)
+	ReadSecret(ctx context.Context, token, url, namespace string, body map[string]interface{}) (*models.Secret, error)
+	RenewLease(ctx context.Context, token, url, namespace, leaseId string, increment int) (*models.Secret, error)
+	GeneratePassword(ctx context.Context, token, url, namespace string) (string, error)
+	Capabilities(ctx context.Context, token, url, namespace string, paths []string) (map[string][]string, error)
+}
+
+// Kubernetes is what the sync asks kubernetes for, apis.Client implements it
+// A Kubernetes given to a Syncer is shared by the workers and has to be safe for concurrent use
+type Kubernetes interface {
+	GetSecret(ctx context.Context, cluster *models.Cluster, ns, objectName, secretName string) (int, map[string]interface{}, error)
+	Create(ctx context.Context, cluster *models.Cluster, ns, objectName, secretName string, status int, payload []byte) (map[string]interface{}, error)
+	CreateEvent(ctx context.Context, cluster *models.Cluster, ns string, payload []byte) error
+	ListNamespaces(ctx context.Context, cluster *models.Cluster, selector string) ([]string, error)
+	ListSecrets(ctx context.Context, cluster *models.Cluster, ns, objectName, selector string) ([]map[string]interface{}, error)
+	Restart(ctx context.Context, cluster *models.Cluster, ns, resource, name, restartedAt string) (int, error)
+}
+
+// Return the kubernetes client of the sync, the one given in the options or the apis client
+func (s *Syncer) kubernetesClient() Kubernetes {
+	if s.opts.Kubernetes != nil {
+		return s.opts.Kubernetes
+	}
+	return &apis.Client{}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// DriftError is returned by a dry-run when at least one secret differs from vault
+type DriftError struct {
+	Drifted int
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("%d secret(s) differ from vault", e.Drifted)
+}
+
+// Diff prints how the secrets in kubernetes differ from vault without writing anything
+// A DriftError is returned when they do, the dry-run is an option of this run only
+func Diff(ctx context.Context, objectName string, out io.Writer) error {
+	opts, err := EnvOptions()
+	if err != nil {
+		return err
+	}
+	opts.ObjectName, opts.DryRun, opts.DiffOutput = objectName, true, out
+	_, err = NewSyncer(opts).Sync(ctx)
+	return err
+}
+
+// Compare the secret rendered from vault with the one in kubernetes the way create does before writing it
+// Returns StatusDrifted and the changes when the secret would be written
+func (s *Syncer) diffSecret(ctx context.Context, cluster *models.Cluster, ns, secretType string, m, annotations map[string]interface{}, objectName, secretObjectName string) (string, []Change, error) {
+	if len(m) == 0 {
+		return StatusUnchanged, nil, nil
+	}
+	_, live, manifest, err := s.render(ctx, cluster, ns, secretType, m, annotations, objectName, secretObjectName)
+	if err != nil {
+		return "", nil, err
+	}
+	var desired map[string]interface{}
+	if err := json.Unmarshal(manifest, &desired); err != nil {
+		return "", nil, err
+	}
+
+	changes := objectChanges(live, desired)
+	if len(changes) == 0 {
+		return StatusUnchanged, nil, nil
+	}
+	return StatusDrifted, changes, nil
+}
+
+// Return the changes between the live object, nil when there's none, and the desired one
+// Like sameObject, the labels and annotations that are not ours are left out
+func objectChanges(live, desired map[string]interface{}) []Change {
+	var changes []Change
+	if live != nil && live["type"] != desired["type"] {
+		changes = append(changes, Change{Field: "type", Action: "changed", From: fmt.Sprint(live["type"]), To: fmt.Sprint(desired["type"])})
+	}
+
+	liveData, _ := live["data"].(map[string]interface{})
+	desiredData, _ := desired["data"].(map[string]interface{})
+	for _, key := range sortedKeys(desiredData, liveData) {
+		from, inLive := liveData[key]
+		to, inDesired := desiredData[key]
+		switch {
+		case !inLive:
+			changes = append(changes, Change{Field: "data", Key: key, Action: "added", To: hashValue(to)})
+		case !inDesired:
+			changes = append(changes, Change{Field: "data", Key: key, Action: "removed", From: hashValue(from)})
+		case from != to:
+			changes = append(changes, Change{Field: "data", Key: key, Action: "changed", From: hashValue(from), To: hashValue(to)})
+		}
+	}
+
+	liveMeta, _ := live["metadata"].(map[string]interface{})
+	desiredMeta, _ := desired["metadata"].(map[string]interface{})
+	for _, field := range []string{"labels", "annotations"} {
+		liveValues, _ := liveMeta[field].(map[string]interface{})
+		desiredValues, _ := desiredMeta[field].(map[string]interface{})
+		for _, key := range sortedKeys(desiredValues, nil) {
+			from, ok := liveValues[key]
+			to := desiredValues[key]
+			if !ok {
+				changes = append(changes, Change{Field: field[:len(field)-1], Key: key, Action: "added", To: fmt.Sprint(to)})
+			} else if from != to {
+				changes = append(changes, Change{Field: field[:len(field)-1], Key: key, Action: "changed", From: fmt.Sprint(from), To: fmt.Sprint(to)})
+			}
+		}
+	}
+	return changes
+}
+
+// Return a short sha256 of a base64 encoded value, enough to tell two values apart without showing them
+func hashValue(value interface{}) string {
+	s, _ := value.(string)
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		decoded = []byte(s)
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(decoded))[:19]
+}
+
+// Return the keys of both maps, sorted
+func sortedKeys(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var ret []string
+	for _, m := range []map[string]interface{}{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				ret = append(ret, key)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Print the secrets that differ and their changes
+func printDiff(w io.Writer, report *Report) {
+	signs := map[string]string{"added": "+", "removed": "-", "changed": "~"}
+	for _, secret := range report.Secrets {
+		if secret.Status != StatusDrifted {
+			continue
+		}
+		fmt.Fprintf(w, "%s/%s\n", secret.Namespace, secret.Name)
+		for _, c := range secret.Changes {
+			switch {
+			case c.Key == "":
+				fmt.Fprintf(w, "  %s %s %s -> %s\n", signs[c.Action], c.Field, c.From, c.To)
+			case c.Action == "added":
+				fmt.Fprintf(w, "  %s %s %s %s\n", signs[c.Action], c.Field, c.Key, c.To)
+			case c.Action == "removed":
+				fmt.Fprintf(w, "  %s %s %s %s\n", signs[c.Action], c.Field, c.Key, c.From)
+			default:
+				fmt.Fprintf(w, "  %s %s %s %s -> %s\n", signs[c.Action], c.Field, c.Key, c.From, c.To)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d drifted, %d unchanged, %d failed, %d skipped\n",
+		report.Drifted, report.Unchanged, report.Failed, report.Skipped)
+}
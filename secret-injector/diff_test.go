@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func Test_syncSecrets_dryRun(t *testing.T) {
+	kv := &fakeKV{
+		data:     map[string]map[string]interface{}{"app/db": {"username": "app", "password": "old-password", "host": "db"}},
+		versions: map[string]int{"app/db": 1},
+	}
+	vault := httptest.NewServer(kv)
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+
+	vars := map[string]*models.SecretSpec{"app-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}}}}
+	opts := kube.options(vault.URL)
+	if _, err := NewSyncer(opts).syncSecrets(context.Background(), vars, "token", nil, false); err != nil {
+		t.Fatalf("syncSecrets() error = %v", err)
+	}
+	kube.reset()
+
+	var out bytes.Buffer
+	opts.DryRun, opts.DiffOutput = true, &out
+	s := NewSyncer(opts)
+	if _, err := s.syncSecrets(context.Background(), vars, "token", nil, false); err != nil {
+		t.Fatalf("syncSecrets() without drift error = %v", err)
+	}
+	if !strings.Contains(out.String(), "0 drifted, 1 unchanged") {
+		t.Errorf("syncSecrets() printed %q, want no drift", out.String())
+	}
+
+	kv.data["app/db"] = map[string]interface{}{"username": "app", "password": "new-password", "port": "5432"}
+	out.Reset()
+	_, err := s.syncSecrets(context.Background(), vars, "token", nil, false)
+	var drift *DriftError
+	if !errors.As(err, &drift) || drift.Drifted != 1 {
+		t.Fatalf("syncSecrets() error = %v, want a drift of 1 secret", err)
+	}
+	if kube.writes() != 0 {
+		t.Errorf("syncSecrets() wrote %d secret(s) in dry-run", kube.writes())
+	}
+
+	want := []string{
+		"sit-sre/app-sit-secret",
+		"  - data host " + hashValue("ZGI="),
+		"  ~ data password " + hashValue("b2xkLXBhc3N3b3Jk") + " -> " + hashValue("bmV3LXBhc3N3b3Jk"),
+		"  + data port " + hashValue("NTQzMg=="),
+		"1 drifted, 0 unchanged, 0 failed, 0 skipped",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("syncSecrets() printed\n%s\nwant\n%s", out.String(), strings.Join(want, "\n"))
+	}
+	if strings.Contains(out.String(), "new-password") {
+		t.Errorf("syncSecrets() printed a value in clear")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	kv := &fakeKV{
+		data:     map[string]map[string]interface{}{"app/db": {"password": "new-password"}},
+		versions: map[string]int{"app/db": 1},
+	}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/" + VaultHealthEndpoint:
+			fmt.Fprintln(w, `{}`)
+			return
+		case "/v1/" + VaultLookupSelfPath:
+			fmt.Fprintln(w, `{"data": {"ttl": 3600}}`)
+			return
+		}
+		kv.ServeHTTP(w, r)
+	}))
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+	kube.add("sit-sre", "app-sit-secret", nil, map[string]interface{}{"password": "old-password"})
+
+	defer func(address, secretPath, method, token, object string) {
+		vaultAddress, vaultSecretPath, vaultAuthMethod, vaultToken, secretObjectJson = address, secretPath, method, token, object
+	}(vaultAddress, vaultSecretPath, vaultAuthMethod, vaultToken, secretObjectJson)
+	vaultAddress, vaultSecretPath, vaultAuthMethod, vaultToken = vault.URL, "secret/data", "token", "token"
+	secretObjectJson = `{"app-sit-secret": ["app/db"]}`
+
+	var out bytes.Buffer
+	err := Diff(context.Background(), "secret", &out)
+	var drift *DriftError
+	if !errors.As(err, &drift) || drift.Drifted != 1 {
+		t.Fatalf("Diff() error = %v, want a drift of 1 secret", err)
+	}
+	if !strings.Contains(out.String(), "1 drifted") {
+		t.Errorf("Diff() printed %q, want the drift", out.String())
+	}
+	if kube.writes() != 0 {
+		t.Errorf("Diff() wrote %d secret(s)", kube.writes())
+	}
+	// A sync run after the diff writes again
+	if err := CreateObject(context.Background(), "secret"); err != nil {
+		t.Fatalf("CreateObject() after Diff() error = %v", err)
+	}
+	if kube.writes() != 1 {
+		t.Errorf("CreateObject() after Diff() wrote %d secret(s), want 1", kube.writes())
+	}
+}
+
+func Test_objectChanges(t *testing.T) {
+	live := map[string]interface{}{
+		"type": "Opaque",
+		"data": map[string]interface{}{"password": "b2xk"},
+		"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{"app.kubernetes.io/name": "app", "team": "sre"},
+			"annotations": map[string]interface{}{VersionsAnnotation: `{"app/db":1}`},
+		},
+	}
+	desired := map[string]interface{}{
+		"type": "kubernetes.io/ssh-auth",
+		"data": map[string]interface{}{"password": "b2xk"},
+		"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{"app.kubernetes.io/name": "app", "app.kubernetes.io/managed-by": "vault-gopher"},
+			"annotations": map[string]interface{}{VersionsAnnotation: `{"app/db":2}`},
+		},
+	}
+	// A label of someone else is left alone, like the write does
+	want := []Change{
+		{Field: "type", Action: "changed", From: "Opaque", To: "kubernetes.io/ssh-auth"},
+		{Field: "label", Key: "app.kubernetes.io/managed-by", Action: "added", To: "vault-gopher"},
+		{Field: "annotation", Key: VersionsAnnotation, Action: "changed", From: `{"app/db":1}`, To: `{"app/db":2}`},
+	}
+	if got := objectChanges(live, desired); !reflect.DeepEqual(got, want) {
+		t.Errorf("objectChanges() got = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"github.com/trx35479/vault-gopher/secret-injector/worker"
+)
+
+// Name of the kubernetes secret of each vault secret when the directory doesn't set one
+const defaultDirectoryName = "{{ .Key }}-{{ .Path }}"
+
+// directoryName is what the name template of a directory is rendered with
+type directoryName struct {
+	// Key of the directory entry in SECRET_OBJECT
+	Key string
+	// Path of the vault secret relative to the folder, ex. db/primary
+	Path string
+	// Last element of the path and the rest of it, ex. primary and db
+	Base string
+	Dir  string
+}
+
+// Replace the directory entries of SECRET_OBJECT by the secrets found in vault
+// The other entries are returned as they are, an entry that can't be listed is returned as an error
+func (s *Syncer) expandDirectories(ctx context.Context, vars map[string]*models.SecretSpec, clientToken string, limiter *worker.Limiter) (map[string]*models.SecretSpec, map[string]error) {
+	ret := make(map[string]*models.SecretSpec, len(vars))
+	errs := make(map[string]error)
+
+	var keys []string
+	for key, spec := range vars {
+		if spec == nil || spec.Directory == nil {
+			ret[key] = spec
+			continue
+		}
+		keys = append(keys, key)
+	}
+	// Sorted so a name generated twice is always reported on the same entry
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		expanded, err := s.expandDirectory(ctx, key, vars[key], clientToken, limiter)
+		if err != nil {
+			if _, ok := err.(*syncFailure); !ok {
+				err = failure(ReasonVaultReadFailed, err)
+			}
+			errs[key] = err
+			continue
+		}
+		// Every name is checked before any is added, a directory with a name that is taken syncs none of its secrets
+		if name := definedName(expanded, ret); name != "" {
+			errs[key] = failure(ReasonInvalidConfig, fmt.Errorf("secret %s of directory %s is already defined", name, key))
+			continue
+		}
+		for name, spec := range expanded {
+			ret[name] = spec
+		}
+	}
+	return ret, errs
+}
+
+// Return the first of the names, in order, that is already defined and an empty string if there's none
+func definedName(names, defined map[string]*models.SecretSpec) string {
+	var taken []string
+	for name := range names {
+		if _, ok := defined[name]; ok {
+			taken = append(taken, name)
+		}
+	}
+	if len(taken) == 0 {
+		return ""
+	}
+	sort.Strings(taken)
+	return taken[0]
+}
+
+// Return the secrets of a single directory entry, keyed by the name of the kubernetes secret
+func (s *Syncer) expandDirectory(ctx context.Context, key string, spec *models.SecretSpec, clientToken string, limiter *worker.Limiter) (map[string]*models.SecretSpec, error) {
+	dir := spec.Directory
+	if len(spec.Paths) != 0 {
+		return nil, failure(ReasonInvalidConfig, fmt.Errorf("directory %s can't have paths as well", key))
+	}
+	mount, err := s.metadataPath()
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(key).Option("missingkey=error").Parse(nameTemplate(dir))
+	if err != nil {
+		return nil, failure(ReasonInvalidConfig, fmt.Errorf("invalid name template of directory %s: %s", key, err))
+	}
+
+	namespace := s.pathNamespace(spec, models.PathSpec{Namespace: dir.Namespace})
+	leaves, err := s.listDirectory(ctx, &RequestUrl{BaseUrl: s.opts.VaultAddress, Path: mount}, dir.Path, clientToken, namespace, limiter)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list directory %s: %s", dir.Path, err)
+	}
+
+	ret := make(map[string]*models.SecretSpec)
+	for _, leaf := range leaves {
+		if !matchDirectory(dir, leaf) {
+			continue
+		}
+		secretPath := models.PathSpec{
+			Path:      path.Join(strings.Trim(dir.Path, "/"), leaf),
+			Namespace: dir.Namespace,
+		}
+		if dir.Merge {
+			if ret[key] == nil {
+				ret[key] = leafSpec(spec)
+			}
+			ret[key].Paths = append(ret[key].Paths, secretPath)
+			continue
+		}
+
+		name, err := renderName(tmpl, key, leaf)
+		if err != nil {
+			return nil, failure(ReasonInvalidConfig, fmt.Errorf("cannot render the name of %s in directory %s: %s", leaf, key, err))
+		}
+		if _, ok := ret[name]; ok {
+			return nil, failure(ReasonInvalidConfig, fmt.Errorf("name %s of directory %s is used by more than one vault secret", name, key))
+		}
+		ret[name] = leafSpec(spec)
+		ret[name].Paths = []models.PathSpec{secretPath}
+	}
+	if len(ret) == 0 {
+		logger.Warnf("directory %s has no vault secret matching its filters", key)
+	}
+	return ret, nil
+}
+
+// Copy the targets of the directory entry into the spec of one of its secrets
+func leafSpec(spec *models.SecretSpec) *models.SecretSpec {
+	return &models.SecretSpec{
+		Type:              spec.Type,
+		VaultNamespace:    spec.VaultNamespace,
+		Namespace:         spec.Namespace,
+		Namespaces:        spec.Namespaces,
+		NamespaceSelector: spec.NamespaceSelector,
+	}
+}
+
+func nameTemplate(dir *models.DirectorySpec) string {
+	if dir.Name == "" {
+		return defaultDirectoryName
+	}
+	return dir.Name
+}
+
+// Render the name template and turn the result into a valid kubernetes name
+func renderName(tmpl *template.Template, key, leaf string) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, directoryName{
+		Key:  key,
+		Path: leaf,
+		Base: path.Base(leaf),
+		Dir:  strings.Trim(path.Dir(leaf), "."),
+	})
+	if err != nil {
+		return "", err
+	}
+	name := sanitizeName(buf.String())
+	if name == "" {
+		return "", fmt.Errorf("template rendered an empty name")
+	}
+	return name, nil
+}
+
+// Lower the case and replace whatever kubernetes doesn't allow in a name with a dash, ex. db/primary_v2 becomes db-primary-v2
+func sanitizeName(name string) string {
+	name = strings.ToLower(name)
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
+
+// Tell if a vault secret of the directory passes the include and exclude filters, exclude wins
+func matchDirectory(dir *models.DirectorySpec, leaf string) bool {
+	if len(dir.Include) != 0 && !matchAny(dir.Include, leaf) {
+		return false
+	}
+	return !matchAny(dir.Exclude, leaf)
+}
+
+func matchAny(patterns []string, leaf string) bool {
+	for _, pattern := range patterns {
+		name := leaf
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(leaf)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LIST the folder and its sub folders and return the vault secrets relative to the folder
+func (s *Syncer) listDirectory(ctx context.Context, metadataUrl *RequestUrl, dir, clientToken, namespace string, limiter *worker.Limiter) ([]string, error) {
+	client, err := s.vaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var leaves []string
+	folders := []string{""}
+	for len(folders) != 0 {
+		folder := folders[0]
+		folders = folders[1:]
+
+		limiter.Wait()
+		keys, err := client.List(ctx, clientToken, metadataUrl.GetPath(path.Join(dir, folder)), namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if strings.HasSuffix(key, "/") {
+				folders = append(folders, folder+key)
+			} else {
+				leaves = append(leaves, folder+key)
+			}
+		}
+	}
+	sort.Strings(leaves)
+	return leaves, nil
+}
+
+// Return the kv v2 metadata mount of VAULT_SECRET_PATH, ex. secret/data/team-a becomes secret/metadata/team-a
+func (s *Syncer) metadataPath() (string, error) {
+	if s.opts.MetadataPath != "" {
+		return s.opts.MetadataPath, nil
+	}
+	parts := strings.Split(strings.Trim(s.opts.SecretPath, "/"), "/")
+	for i, part := range parts {
+		if part == "data" {
+			parts[i] = "metadata"
+			return strings.Join(parts, "/"), nil
+		}
+	}
+	return "", fmt.Errorf("cannot find the kv v2 metadata path of %s, set VAULT_METADATA_PATH", s.opts.SecretPath)
+}
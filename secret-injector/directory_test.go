@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func Test_expandDirectories(t *testing.T) {
+	folders := map[string]string{
+		"/v1/secret/metadata/team-a":       `["db/", "api", "tmp-key"]`,
+		"/v1/secret/metadata/team-a/db":    `["primary", "replica_1"]`,
+		"/v1/secret/metadata/team-a/empty": ``,
+		"/v1/secret/metadata/team-b":       `["api"]`,
+	}
+	// The token can't list team-c
+	forbidden := "/v1/secret/metadata/team-c"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "LIST" {
+			t.Errorf("method is incorrect: %s", r.Method)
+		}
+		if r.URL.Path == forbidden {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		keys, ok := folders[r.URL.Path]
+		if !ok || keys == "" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"errors": []}`)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"keys": %s}}`, keys)
+	}))
+	defer server.Close()
+
+	s := NewSyncer(Options{VaultAddress: server.URL, SecretPath: "secret/data"})
+
+	tests := []struct {
+		name string
+		vars map[string]*models.SecretSpec
+		want map[string]*models.SecretSpec
+		// Key of the failed entries and the reason of their failure
+		wantErrs []string
+	}{
+		{
+			name: "one-per-secret",
+			vars: map[string]*models.SecretSpec{
+				"team-a": {
+					Directory:  &models.DirectorySpec{Path: "team-a", Exclude: []string{"tmp-*"}},
+					Namespaces: []string{"sit-sre"},
+				},
+				"app-secret": {Paths: []models.PathSpec{{Path: "app/db"}}},
+			},
+			want: map[string]*models.SecretSpec{
+				"team-a-api":          {Paths: []models.PathSpec{{Path: "team-a/api"}}, Namespaces: []string{"sit-sre"}},
+				"team-a-db-primary":   {Paths: []models.PathSpec{{Path: "team-a/db/primary"}}, Namespaces: []string{"sit-sre"}},
+				"team-a-db-replica-1": {Paths: []models.PathSpec{{Path: "team-a/db/replica_1"}}, Namespaces: []string{"sit-sre"}},
+				"app-secret":          {Paths: []models.PathSpec{{Path: "app/db"}}},
+			},
+		},
+		{
+			name: "template-and-include",
+			vars: map[string]*models.SecretSpec{
+				"team-a": {Directory: &models.DirectorySpec{
+					Path:    "team-a",
+					Name:    "{{ .Base }}-db-secret",
+					Include: []string{"db/*"},
+				}},
+			},
+			want: map[string]*models.SecretSpec{
+				"primary-db-secret":   {Paths: []models.PathSpec{{Path: "team-a/db/primary"}}},
+				"replica-1-db-secret": {Paths: []models.PathSpec{{Path: "team-a/db/replica_1"}}},
+			},
+		},
+		{
+			name: "merge",
+			vars: map[string]*models.SecretSpec{
+				"team-a-secret": {Directory: &models.DirectorySpec{Path: "team-a", Namespace: "team-a", Merge: true, Exclude: []string{"tmp-*"}}},
+			},
+			want: map[string]*models.SecretSpec{
+				"team-a-secret": {Paths: []models.PathSpec{
+					{Path: "team-a/api", Namespace: "team-a"},
+					{Path: "team-a/db/primary", Namespace: "team-a"},
+					{Path: "team-a/db/replica_1", Namespace: "team-a"},
+				}},
+			},
+		},
+		{
+			name: "empty",
+			vars: map[string]*models.SecretSpec{
+				"team-a": {Directory: &models.DirectorySpec{Path: "team-a/empty"}},
+			},
+			want: map[string]*models.SecretSpec{},
+		},
+		{
+			name: "name-clash",
+			vars: map[string]*models.SecretSpec{
+				"team-b":     {Directory: &models.DirectorySpec{Path: "team-b"}},
+				"team-b-api": {Paths: []models.PathSpec{{Path: "team-b/api"}}},
+			},
+			want: map[string]*models.SecretSpec{
+				"team-b-api": {Paths: []models.PathSpec{{Path: "team-b/api"}}},
+			},
+			wantErrs: []string{"team-b InvalidConfig"},
+		},
+		{
+			// The other secrets of the directory are not synced either
+			name: "partial-clash",
+			vars: map[string]*models.SecretSpec{
+				"team-a":            {Directory: &models.DirectorySpec{Path: "team-a"}},
+				"team-a-db-primary": {Paths: []models.PathSpec{{Path: "app/db"}}},
+			},
+			want: map[string]*models.SecretSpec{
+				"team-a-db-primary": {Paths: []models.PathSpec{{Path: "app/db"}}},
+			},
+			wantErrs: []string{"team-a InvalidConfig"},
+		},
+		{
+			name: "forbidden",
+			vars: map[string]*models.SecretSpec{
+				"team-c": {Directory: &models.DirectorySpec{Path: "team-c"}},
+			},
+			want:     map[string]*models.SecretSpec{},
+			wantErrs: []string{"team-c VaultReadFailed"},
+		},
+		{
+			name: "paths-and-directory",
+			vars: map[string]*models.SecretSpec{
+				"team-b": {Directory: &models.DirectorySpec{Path: "team-b"}, Paths: []models.PathSpec{{Path: "app/db"}}},
+			},
+			want:     map[string]*models.SecretSpec{},
+			wantErrs: []string{"team-b InvalidConfig"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := s.expandDirectories(context.Background(), tt.vars, "token", nil)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandDirectories() got = %v, want %v", got, tt.want)
+			}
+			var gotErrs []string
+			for key, err := range errs {
+				gotErrs = append(gotErrs, key+" "+failureReason(err))
+			}
+			if !reflect.DeepEqual(gotErrs, tt.wantErrs) {
+				t.Errorf("expandDirectories() errors = %v, want %v", errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func Test_metadataPath(t *testing.T) {
+	tests := []struct {
+		secretPath string
+		metadata   string
+		want       string
+		wantErr    bool
+	}{
+		{secretPath: "secret/data", want: "secret/metadata"},
+		{secretPath: "/kv/data/team-a/", want: "kv/metadata/team-a"},
+		{secretPath: "kv/team-a", metadata: "kv/metadata/team-a", want: "kv/metadata/team-a"},
+		{secretPath: "kv/team-a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.secretPath, func(t *testing.T) {
+			s := NewSyncer(Options{SecretPath: tt.secretPath, MetadataPath: tt.metadata})
+			got, err := s.metadataPath()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("metadataPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("metadataPath() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// Event types understood by kubernetes
+	EventNormal  = "Normal"
+	EventWarning = "Warning"
+
+	// Reasons of the events posted on the secrets
+	ReasonSynced          = "Synced"
+	ReasonUnchanged       = "Unchanged"
+	ReasonVaultReadFailed = "VaultReadFailed"
+	ReasonDecryptFailed   = "DecryptFailed"
+	ReasonBootstrapFailed = "BootstrapFailed"
+	ReasonApplyConflict   = "ApplyConflict"
+	ReasonApplyFailed     = "ApplyFailed"
+	// The secret targets a namespace outside of ALLOWED_NAMESPACES
+	ReasonNamespaceNotAllowed = "NamespaceNotAllowed"
+	// The entry of SECRET_OBJECT can't be synced as it is written, ex. a directory naming a secret that is already defined
+	ReasonInvalidConfig = "InvalidConfig"
+
+	// Reasons of the summary event posted on the pod of the job
+	ReasonSyncCompleted = "SyncCompleted"
+	ReasonSyncFailed    = "SyncFailed"
+
+	// MicroTime format of the events.k8s.io api
+	eventTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// Kubernetes object reference, points the event to the object it's about
+type ObjectReference struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+}
+
+// Kubernetes event object root struct, events.k8s.io/v1
+type Event struct {
+	ApiVersion          string           `json:"apiVersion"`
+	Kind                string           `json:"kind"`
+	Metadata            *Meta            `json:"metadata"`
+	EventTime           string           `json:"eventTime"`
+	ReportingController string           `json:"reportingController"`
+	ReportingInstance   string           `json:"reportingInstance"`
+	Action              string           `json:"action"`
+	Reason              string           `json:"reason"`
+	Note                string           `json:"note"`
+	Type                string           `json:"type"`
+	Regarding           ObjectReference  `json:"regarding"`
+	Related             *ObjectReference `json:"related,omitempty"`
+}
+
+// syncFailure carries the reason of a failed secret so the right event can be posted
+type syncFailure struct {
+	reason string
+	err    error
+}
+
+func (f *syncFailure) Error() string {
+	return f.err.Error()
+}
+
+func (f *syncFailure) Unwrap() error {
+	return f.err
+}
+
+// Wrap an error with the reason that will be used in the event
+func failure(reason string, err error) error {
+	return &syncFailure{reason: reason, err: err}
+}
+
+// Return the event reason of an error, errors without one are reported as a failed apply
+func failureReason(err error) string {
+	if f, ok := err.(*syncFailure); ok {
+		return f.reason
+	}
+	return ReasonApplyFailed
+}
+
+// Reference to the pod we are running in
+// The pod name is injected through the downward api as POD_NAME, the hostname is used otherwise
+// Outside of a pod there is nothing to link to and nil is returned
+func podReference(ns string) *ObjectReference {
+	name := os.Getenv("POD_NAME")
+	if name == "" && kubernetesServiceHost != "" {
+		name, _ = os.Hostname()
+	}
+	if name == "" {
+		return nil
+	}
+	if podNs := os.Getenv("POD_NAMESPACE"); podNs != "" {
+		ns = podNs
+	}
+	return &ObjectReference{
+		ApiVersion: "v1",
+		Kind:       "Pod",
+		Name:       name,
+		Namespace:  ns,
+	}
+}
+
+// Construct the kubernetes event manifest and return it as a byte
+// the event is about regarding and optionally linked to related
+func event(regarding ObjectReference, related *ObjectReference, eventType, reason, note string) ([]byte, error) {
+	if regarding.Name == "" || regarding.Namespace == "" {
+		return nil, fmt.Errorf("event needs the name and namespace of the object")
+	}
+	now := time.Now().UTC()
+	host, _ := os.Hostname()
+
+	manifest := &Event{
+		ApiVersion: "events.k8s.io/v1",
+		Kind:       "Event",
+		Metadata: &Meta{
+			// Event names need to be unique, the same convention kubectl uses
+			Name:      fmt.Sprintf("%s.%x", regarding.Name, now.UnixNano()),
+			Namespace: regarding.Namespace,
+		},
+		EventTime:           now.Format(eventTimeFormat),
+		ReportingController: "vault-gopher",
+		ReportingInstance:   host,
+		Action:              "Sync",
+		Reason:              reason,
+		Note:                note,
+		Type:                eventType,
+		Regarding:           regarding,
+		Related:             related,
+	}
+
+	ret, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func Test_event(t *testing.T) {
+	secret := ObjectReference{
+		ApiVersion: "v1",
+		Kind:       "Secret",
+		Name:       "app-sit-secret",
+		Namespace:  "sit-sre",
+	}
+	pod := &ObjectReference{
+		ApiVersion: "v1",
+		Kind:       "Pod",
+		Name:       "vault-gopher-xyz",
+		Namespace:  "sit-sre",
+	}
+	tests := []struct {
+		name      string
+		regarding ObjectReference
+		related   *ObjectReference
+		wantErr   bool
+	}{
+		{
+			name:      "secret-and-pod",
+			regarding: secret,
+			related:   pod,
+			wantErr:   false,
+		},
+		{
+			name:      "secret-only",
+			regarding: secret,
+			wantErr:   false,
+		},
+		{
+			name:      "missing-namespace",
+			regarding: ObjectReference{Kind: "Secret", Name: "app-sit-secret"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := event(tt.regarding, tt.related, EventWarning, ReasonVaultReadFailed, "permission denied")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("event() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var e Event
+			if err := json.Unmarshal(got, &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Regarding != tt.regarding {
+				t.Errorf("event() regarding = %v, want %v", e.Regarding, tt.regarding)
+			}
+			if (e.Related == nil) != (tt.related == nil) {
+				t.Errorf("event() related = %v, want %v", e.Related, tt.related)
+			}
+			if e.Metadata.Namespace != tt.regarding.Namespace {
+				t.Errorf("event() namespace = %s, want %s", e.Metadata.Namespace, tt.regarding.Namespace)
+			}
+			if e.Reason != ReasonVaultReadFailed || e.Type != EventWarning {
+				t.Errorf("event() reason = %s type = %s", e.Reason, e.Type)
+			}
+		})
+	}
+}
+
+func Test_failureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "vault",
+			err:  failure(ReasonVaultReadFailed, errors.New("403")),
+			want: ReasonVaultReadFailed,
+		},
+		{
+			name: "conflict",
+			err:  failure(ReasonApplyConflict, errors.New("409")),
+			want: ReasonApplyConflict,
+		},
+		{
+			name: "plain",
+			err:  errors.New("boom"),
+			want: ReasonApplyFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failureReason(tt.err); got != tt.want {
+				t.Errorf("failureReason() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
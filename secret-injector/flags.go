@@ -0,0 +1,77 @@
+package handler
+
+import "flag"
+
+// DryRunFlag registers --dry-run on the flag set of a sync, its default is read from DRY_RUN
+// A dry-run renders the secrets and prints how they differ from kubernetes instead of writing them,
+// it's only asked for by the command, EnvOptions leaves Options.DryRun off
+func DryRunFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("dry-run", getEnvBool("DRY_RUN", false), "print how the secrets differ from vault instead of writing them (env DRY_RUN)")
+}
+
+// Flags registers a flag for every env variable of the app on the flag set
+// The default of each flag is the value read from the env, so a flag given on the command line
+// takes precedence over the env, which takes precedence over the default of the app
+func Flags(fs *flag.FlagSet) {
+	// Vault connection
+	fs.StringVar(&vaultAddress, "vault-addr", vaultAddress, "address of vault, ex. https://vault:8200 (env VAULT_ADDR)")
+	fs.StringVar(&vaultNamespace, "vault-namespace", vaultNamespace, "vault namespace of the secrets (env VAULT_NAMESPACE)")
+	fs.StringVar(&vaultCACert, "vault-cacert", vaultCACert, "CA certificate vault is verified with (env VAULT_CACERT)")
+	fs.StringVar(&vaultClientCert, "vault-client-cert", vaultClientCert, "client certificate sent to vault (env VAULT_CLIENT_CERT)")
+	fs.StringVar(&vaultClientKey, "vault-client-key", vaultClientKey, "key of the client certificate (env VAULT_CLIENT_KEY)")
+	fs.BoolVar(&vaultSkipVerify, "vault-skip-verify", vaultSkipVerify, "don't verify the certificate of vault (env VAULT_SKIP_VERIFY)")
+	fs.StringVar(&vaultConsistency, "vault-consistency", vaultConsistency,
+		"none, forward-active-node, index-forward or index-retry (env VAULT_CONSISTENCY)")
+	fs.StringVar(&vaultIndex, "vault-index", vaultIndex, "index of the write the reads should see (env VAULT_INDEX)")
+	fs.IntVar(&vaultConsistencyRetries, "vault-consistency-retries", vaultConsistencyRetries,
+		"retries of index-retry before giving up (env VAULT_CONSISTENCY_RETRIES)")
+	fs.Float64Var(&vaultRateLimit, "rate-limit", vaultRateLimit, "requests per second sent to vault, 0 disables the limit (env VAULT_RATE_LIMIT)")
+
+	// Vault login
+	fs.StringVar(&vaultAuthMethod, "auth-method", vaultAuthMethod,
+		"kubernetes, approle, jwt, cert or token, taken from the auth path when empty (env VAULT_AUTH_METHOD)")
+	fs.StringVar(&vaultAuthPath, "vault-auth-path", vaultAuthPath, "path of the auth method, ex. auth/kubernetes (env VAULT_AUTH_PATH)")
+	fs.StringVar(&vaultAuthNamespace, "vault-auth-namespace", vaultAuthNamespace,
+		"vault namespace of the auth method, the one of the secrets when empty (env VAULT_AUTH_NAMESPACE)")
+	fs.StringVar(&appRoleName, "approle-name", appRoleName, "role logged in with (env APPROLE_NAME)")
+	fs.StringVar(&vaultToken, "vault-token", vaultToken,
+		"token of the token auth method, prefer the env or a file as flags show up in the process list (env VAULT_TOKEN)")
+	fs.StringVar(&vaultTokenFile, "vault-token-file", vaultTokenFile, "file the token of the token auth method is read from (env VAULT_TOKEN_FILE)")
+	fs.BoolVar(&appRoleSecretIdWrapped, "approle-secret-id-wrapped", appRoleSecretIdWrapped,
+		"the secret id file holds a response wrapping token (env APPROLE_SECRET_ID_WRAPPED)")
+	fs.StringVar(&appRoleWrappedCreationPath, "approle-wrapped-creation-path", appRoleWrappedCreationPath,
+		"pattern the creation path of the wrapping token must match (env APPROLE_WRAPPED_CREATION_PATH)")
+	fs.StringVar(&vaultJwtPath, "vault-jwt-path", vaultJwtPath, "file of the jwt of the jwt auth method (env VAULT_JWT_PATH)")
+	fs.StringVar(&vaultJwtRole, "vault-jwt-role", vaultJwtRole, "role of the jwt auth method, the approle name when empty (env VAULT_JWT_ROLE)")
+	fs.StringVar(&vaultCertRole, "vault-cert-role", vaultCertRole, "role of the cert auth method (env VAULT_CERT_ROLE)")
+
+	// Vault mounts
+	fs.StringVar(&vaultSecretPath, "vault-secret-path", vaultSecretPath, "kv path the secret paths are under, ex. secret/data (env VAULT_SECRET_PATH)")
+	fs.StringVar(&vaultMetadataPath, "vault-metadata-path", vaultMetadataPath,
+		"kv v2 metadata path, derived from the secret path when empty (env VAULT_METADATA_PATH)")
+	fs.StringVar(&vaultTransitPath, "vault-transit-path", vaultTransitPath, "mount of the transit secrets engine (env VAULT_TRANSIT_PATH)")
+	fs.StringVar(&vaultSSHPath, "vault-ssh-path", vaultSSHPath, "mount of the ssh secrets engine (env VAULT_SSH_PATH)")
+	fs.StringVar(&vaultAWSPath, "vault-aws-path", vaultAWSPath, "mount of the aws secrets engine (env VAULT_AWS_PATH)")
+
+	// Kubernetes
+	fs.StringVar(&Kube.Kubeconfig, "kubeconfig", Kube.Kubeconfig,
+		"path of the kubeconfig, the in-cluster service account is used when empty (env KUBECONFIG)")
+	fs.StringVar(&Kube.Context, "context", Kube.Context, "kubeconfig context to use, defaults to the current-context (env KUBE_CONTEXT)")
+	fs.StringVar(&Kube.Namespace, "namespace", Kube.Namespace,
+		"namespace the secrets are written to, defaults to the one of the context or service account (env KUBE_NAMESPACE)")
+	fs.StringVar(&ObjectName, "object-name", ObjectName, "resource the secrets are written as in the kubernetes api (env OBJECT_NAME)")
+	fs.StringVar(&allowedNamespaces, "allowed-namespaces", allowedNamespaces,
+		"comma separated patterns of the other namespaces secrets may be written to (env ALLOWED_NAMESPACES)")
+
+	// Sync
+	fs.StringVar(&secretObjectJson, "secret-object", secretObjectJson, "json of the secrets to sync (env SECRET_OBJECT)")
+	fs.StringVar(&SecretObjectFile, "config", SecretObjectFile, "file holding the json of the secrets to sync, it takes over SECRET_OBJECT (env SECRET_OBJECT_FILE)")
+	fs.IntVar(&syncConcurrency, "concurrency", syncConcurrency, "secrets processed at the same time (env SYNC_CONCURRENCY)")
+	fs.BoolVar(&continueOnError, "continue-on-error", continueOnError, "write the other secrets when one fails (env CONTINUE_ON_ERROR)")
+	fs.StringVar(&syncReport, "report", syncReport, "where to write the json report, - for stdout (env SYNC_REPORT)")
+	fs.BoolVar(&bootstrapSecrets, "bootstrap", bootstrapSecrets, "generate the missing keys and write them to vault (env BOOTSTRAP_SECRETS)")
+	fs.BoolVar(&vaultTrackVersions, "track-versions", vaultTrackVersions,
+		"only fetch the secrets whose kv v2 version has changed (env VAULT_TRACK_VERSIONS)")
+	fs.DurationVar(&SyncTimeout, "timeout", SyncTimeout, "how long a sync may take, the login included, 0 for no limit (env SYNC_TIMEOUT)")
+	fs.DurationVar(&SyncInterval, "interval", SyncInterval, "keep running and sync again after each interval, ex. 30s (env SYNC_INTERVAL)")
+}
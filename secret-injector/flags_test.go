@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFlags(t *testing.T) {
+	defer func(address string, concurrency int, interval time.Duration) {
+		vaultAddress, syncConcurrency, SyncInterval = address, concurrency, interval
+	}(vaultAddress, syncConcurrency, SyncInterval)
+
+	tests := []struct {
+		name        string
+		args        []string
+		address     string
+		concurrency int
+		interval    time.Duration
+	}{
+		{
+			// The values read from the env are kept when no flag is given
+			name:        "env",
+			address:     "https://env:8200",
+			concurrency: 4,
+		},
+		{
+			name:        "flags",
+			args:        []string{"--vault-addr", "https://flag:8200", "--concurrency", "8", "--interval", "30s"},
+			address:     "https://flag:8200",
+			concurrency: 8,
+			interval:    30 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vaultAddress, syncConcurrency, SyncInterval = "https://env:8200", 4, 0
+			fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+			Flags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if vaultAddress != tt.address || syncConcurrency != tt.concurrency || SyncInterval != tt.interval {
+				t.Errorf("Flags() = %s, %d, %s, want %s, %d, %s", vaultAddress, syncConcurrency, SyncInterval,
+					tt.address, tt.concurrency, tt.interval)
+			}
+		})
+	}
+}
+
+func TestDryRunFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want bool
+	}{
+		{name: "default"},
+		{name: "env", env: "true", want: true},
+		{name: "flag", args: []string{"--dry-run"}, want: true},
+		{name: "flag-off", env: "true", args: []string{"--dry-run=false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("DRY_RUN", tt.env)
+			defer os.Unsetenv("DRY_RUN")
+			fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+			Flags(fs)
+			dryRun := DryRunFlag(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if *dryRun != tt.want {
+				t.Errorf("DryRunFlag() = %v, want %v", *dryRun, tt.want)
+			}
+			// The options of the env never ask for a dry-run on their own
+			if envOptions().DryRun {
+				t.Errorf("envOptions() DryRun = true, want false")
+			}
+		})
+	}
+}
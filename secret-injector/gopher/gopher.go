@@ -0,0 +1,54 @@
+// Package gopher runs the sync of vault-gopher in-process, without the env or the command line
+//
+//	opts := gopher.DefaultOptions()
+//	opts.VaultAddress = "https://vault:8200"
+//	opts.SecretPath = "secret/data"
+//	opts.Secrets = map[string]*gopher.SecretSpec{"billing-sit-secret": {Paths: []gopher.PathSpec{{Path: "billing/db"}}}}
+//	report, err := gopher.New(opts).Sync(ctx)
+package gopher
+
+import (
+	handler "github.com/trx35479/vault-gopher/secret-injector"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+type (
+	// Options tells a Syncer what to sync and how, each field is the counterpart of an env variable of the app
+	Options = handler.Options
+	// SecretSpec is a secret of SECRET_OBJECT
+	SecretSpec = models.SecretSpec
+	// PathSpec is a vault path of a secret
+	PathSpec = models.PathSpec
+	// KubeOptions tells how to reach the kubernetes api
+	KubeOptions = handler.KubeOptions
+	// Syncer syncs the secrets of its options from vault to kubernetes
+	Syncer = handler.Syncer
+	// Vault is what the sync asks vault for, set it in the options to replace the http client
+	Vault = handler.Vault
+	// Kubernetes is what the sync asks kubernetes for, set it in the options to replace the http client
+	Kubernetes = handler.Kubernetes
+	// Report is the outcome of a sync
+	Report = handler.Report
+	// SecretReport is the outcome of a single kubernetes secret
+	SecretReport = handler.SecretReport
+	// SyncError is returned with the report when some of the secrets failed
+	SyncError = handler.SyncError
+	// DriftError is returned with the report of a dry-run when some of the secrets differ from vault
+	DriftError = handler.DriftError
+)
+
+// New returns a Syncer of the options
+// Each Syncer keeps its own settings, the Syncers of a process can run at the same time
+func New(opts Options) *Syncer {
+	return handler.NewSyncer(opts)
+}
+
+// DefaultOptions returns the options of the app when nothing is set in the env
+func DefaultOptions() Options {
+	return handler.DefaultOptions()
+}
+
+// EnvOptions returns the options the app would run with, read from the env
+func EnvOptions() (Options, error) {
+	return handler.EnvOptions()
+}
@@ -2,15 +2,20 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/trx35479/vault-gopher/secret-injector/apis"
-	"github.com/trx35479/vault-gopher/secret-injector/utils"
+	"github.com/trx35479/vault-gopher/secret-injector/auth"
+	"github.com/trx35479/vault-gopher/secret-injector/kubeconfig"
+	"github.com/trx35479/vault-gopher/secret-injector/log"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
 )
 
 const (
@@ -24,20 +29,40 @@ const (
 
 	// We need to revoke the keys right after secrets have been provided
 	// This path is a constant value since its the same path regardless of authentication method you use to authenticate to vault
-	//VaultRevokeAuthPath = "auth/token/revoke-self"
+	VaultRevokeAuthPath = "auth/token/revoke-self"
+	// Tells if a token is still valid, same path for every authentication method as well
+	VaultLookupSelfPath = "auth/token/lookup-self"
 
 	// Vault health endpoint
 	// we will use this endpoint to check the status of vault before we send a request
 	VaultHealthEndpoint = "sys/health"
 )
 
+var logger = log.NewLogger()
+
+// Settings of the env and the command line flags, they are only read by EnvOptions
+// A sync reads the Options of its Syncer instead
 var (
 	// Aligned variables from vault configuration
 	// APPROLE_NAME is the role in vault that has an attached policy to access specific secret
 	// this should name be confused with approle authentication method
 	vaultAddress   = os.Getenv("VAULT_ADDR")
 	vaultNamespace = os.Getenv("VAULT_NAMESPACE")
-	appRoleName    = os.Getenv("APPROLE_NAME")
+	// Namespace the login is sent to when the auth method lives somewhere else than the secrets, VAULT_NAMESPACE by default
+	vaultAuthNamespace = os.Getenv("VAULT_AUTH_NAMESPACE")
+	appRoleName        = os.Getenv("APPROLE_NAME")
+
+	// Name of the auth method, one of kubernetes, approle, jwt, cert or token
+	// it's taken from VAULT_AUTH_PATH when not set, which only works for the default auth/<method> mount
+	vaultAuthMethod = os.Getenv("VAULT_AUTH_METHOD")
+	// Token of the token auth method, or the file it's read from such as the sink of vault agent
+	vaultToken     = os.Getenv("VAULT_TOKEN")
+	vaultTokenFile = os.Getenv("VAULT_TOKEN_FILE")
+
+	// The file mounted for the approle auth method holds a response wrapping token instead of the secret id
+	// and the pattern the creation path of the wrapping token must match
+	appRoleSecretIdWrapped     = getEnvBool("APPROLE_SECRET_ID_WRAPPED", false)
+	appRoleWrappedCreationPath = os.Getenv("APPROLE_WRAPPED_CREATION_PATH")
 
 	// This is the variables names the app will use and it's not related to vault
 	// should not be confused with vault variables or terminology
@@ -45,8 +70,67 @@ var (
 	vaultAuthPath         = os.Getenv("VAULT_AUTH_PATH")
 	vaultSecretPath       = os.Getenv("VAULT_SECRET_PATH")
 	kubernetesServiceHost = os.Getenv("KUBERNETES_SERVICE_HOST")
+	kubernetesServicePort = os.Getenv("KUBERNETES_SERVICE_PORT")
+
+	// Metadata path of the kv v2 mount that is listed for the directory entries, it's derived from VAULT_SECRET_PATH when not set
+	vaultMetadataPath = os.Getenv("VAULT_METADATA_PATH")
+	// Mount of the transit secrets engine the keys marked with transit are decrypted with
+	vaultTransitPath = getEnvDefault("VAULT_TRANSIT_PATH", defaults.TransitPath)
+	// Mount of the ssh secrets engine the ssh secrets are signed with
+	vaultSSHPath = getEnvDefault("VAULT_SSH_PATH", defaults.SSHPath)
+	// Mount of the aws secrets engine the aws secrets are issued by
+	vaultAWSPath = getEnvDefault("VAULT_AWS_PATH", defaults.AWSPath)
+
+	// Path of the projected service account token used by the jwt auth method
+	vaultJwtPath = getEnvDefault("VAULT_JWT_PATH", defaults.JwtPath)
+	// Role of the jwt auth method, APPROLE_NAME is used when it's not set
+	vaultJwtRole = os.Getenv("VAULT_JWT_ROLE")
+
+	// TLS of the vault connection, the client certificate and key are what the cert auth method logs in with
+	vaultCACert     = os.Getenv("VAULT_CACERT")
+	vaultClientCert = os.Getenv("VAULT_CLIENT_CERT")
+	vaultClientKey  = os.Getenv("VAULT_CLIENT_KEY")
+	vaultSkipVerify = getEnvBool("VAULT_SKIP_VERIFY", false)
+	// Name of the certificate role of the cert auth method, vault tries every role when it's empty
+	vaultCertRole = os.Getenv("VAULT_CERT_ROLE")
+
+	// How reads from performance standbys are kept consistent with the writes made just before the sync
+	// one of none, forward-active-node, index-forward or index-retry, VAULT_INDEX is the index of that write
+	vaultConsistency        = getEnvDefault("VAULT_CONSISTENCY", defaults.Consistency)
+	vaultIndex              = os.Getenv("VAULT_INDEX")
+	vaultConsistencyRetries = getEnvInt("VAULT_CONSISTENCY_RETRIES", defaults.ConsistencyRetries)
+
+	// Number of vault paths and kubernetes secrets that are processed at the same time
+	syncConcurrency = getEnvInt("SYNC_CONCURRENCY", defaults.Concurrency)
+	// Maximum number of requests per second sent to vault while fetching the secrets, 0 disables the limit
+	vaultRateLimit = getEnvFloat("VAULT_RATE_LIMIT", defaults.RateLimit)
+	// Write the other secrets when one of them fails, every secret is read and its failures reported either way
+	continueOnError = getEnvBool("CONTINUE_ON_ERROR", false)
+	// Where to write the json report of the sync, "-" for stdout or a file path
+	syncReport = os.Getenv("SYNC_REPORT")
+	// Comma separated patterns of the namespaces the secrets may be written to besides the default one, ex. sit-*,uat-sre
+	allowedNamespaces = os.Getenv("ALLOWED_NAMESPACES")
+	// Generate the keys marked with generate that are missing in vault and write them back before the sync
+	bootstrapSecrets = getEnvBool("BOOTSTRAP_SECRETS", false)
+	// Read the kv v2 metadata first and only fetch the secrets whose version has changed, on by default in Watch
+	vaultTrackVersions = getEnvBool("VAULT_TRACK_VERSIONS", false)
 )
 
+// The json of the secrets to sync, SecretObjectFile takes over it
+var secretObjectJson = string(getEnv("SECRET_OBJECT"))
+
+// SecretObjectFile is the path of a file holding the json of SECRET_OBJECT, ex. a mounted config map, it takes over the env
+var SecretObjectFile = os.Getenv("SECRET_OBJECT_FILE")
+
+// SyncInterval keeps the app running and syncs again after each interval, 0 syncs once and exits
+var SyncInterval = getEnvDuration("SYNC_INTERVAL", 0)
+
+// SyncTimeout limits how long a sync run may take, the login included, 0 lets it run until it's done
+var SyncTimeout = getEnvDuration("SYNC_TIMEOUT", 0)
+
+// ObjectName is the resource of the secrets in the path of the kubernetes api
+var ObjectName = getEnvDefault("OBJECT_NAME", defaults.ObjectName)
+
 // This type gives us the ability to mutate the request url
 // By creating a method that gets the absolute path of request url
 type RequestUrl struct {
@@ -62,185 +146,303 @@ func getEnv(v string) []byte {
 	b := bytes.NewBufferString(env)
 	data, err := ioutil.ReadAll(b)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal(err)
 	}
 	return data
 }
 
+// Read a string from env and fallback to the default value if it's not set
+func getEnvDefault(v, def string) string {
+	if env := os.Getenv(v); env != "" {
+		return env
+	}
+	return def
+}
+
+// Read an integer from env and fallback to the default value if it's not set
+func getEnvInt(v string, def int) int {
+	env := os.Getenv(v)
+	if env == "" {
+		return def
+	}
+	i, err := strconv.Atoi(env)
+	if err != nil {
+		logger.Fatalf("variable %s is not a valid integer: %s", v, env)
+	}
+	return i
+}
+
+// Read a boolean from env and fallback to the default value if it's not set
+func getEnvBool(v string, def bool) bool {
+	env := os.Getenv(v)
+	if env == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(env)
+	if err != nil {
+		logger.Fatalf("variable %s is not a valid boolean: %s", v, env)
+	}
+	return b
+}
+
+// Read a float from env and fallback to the default value if it's not set
+func getEnvFloat(v string, def float64) float64 {
+	env := os.Getenv(v)
+	if env == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(env, 64)
+	if err != nil {
+		logger.Fatalf("variable %s is not a valid number: %s", v, env)
+	}
+	return f
+}
+
+// Read a duration such as 30s from env and fallback to the default value if it's not set
+func getEnvDuration(v string, def time.Duration) time.Duration {
+	env := os.Getenv(v)
+	if env == "" {
+		return def
+	}
+	d, err := time.ParseDuration(env)
+	if err != nil {
+		logger.Fatalf("variable %s is not a valid duration: %s", v, env)
+	}
+	return d
+}
+
 // GetPath the absolute path, we have arbitrary path on api calls on vault and this method returns an clean path
 func (s *RequestUrl) GetPath(p string) string {
 	if s.Path == "" {
-		log.Println("Path is need to get the absolute request url")
+		logger.Println("Path is need to get the absolute request url")
 	}
 	if s.BaseUrl == "" {
-		log.Println("Url is need to get the absolute request url")
+		logger.Println("Url is need to get the absolute request url")
 	}
 	return strings.Trim(s.BaseUrl, "/") + "/v1" + "/" + strings.Trim(s.Path, "/") + "/" + strings.Trim(p, "/")
 }
 
 // Main handler that perform the api calls to vault and kubernetes
-// this is called from the main function and returns data structure depending on the result of api calls
-func CreateObject(objectName string) error {
-	var client apis.Client
+// this is called from the main function and syncs once with the options of the env and the flags
+func CreateObject(ctx context.Context, objectName string) error {
+	opts, err := EnvOptions()
+	if err != nil {
+		return err
+	}
+	opts.ObjectName = objectName
+	_, err = NewSyncer(opts).Sync(ctx)
+	return err
+}
+
+// Return the context of a sync run, cancelled once the timeout is over when there's one
+func runContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
 
-	// Read the mounted token so we can use it by adding it the vault-token header in http request
-	vaultToken, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", VaultAuthenticationPath, "token"))
+// Watch keeps the secrets in sync with vault and syncs again after every interval
+// The payloads are kept in memory and the token is renewed by logging in again before it expires
+// A failing sync or login is logged and tried again at the next interval, only a bad configuration is returned
+// It stops once the context is done, the token is revoked on the way out
+func Watch(ctx context.Context, objectName string, interval time.Duration, trackVersions bool) error {
+	opts, err := EnvOptions()
 	if err != nil {
-		return fmt.Errorf("cannot read vault token error %v", err)
+		return err
 	}
+	opts.ObjectName, opts.TrackVersions = objectName, trackVersions
+	return NewSyncer(opts).watch(ctx, interval)
+}
 
-	// We get the client token to be used to get the secrets
-	// Check the authentication method used
-	// authPath is in the format of auth/"method", where method is either kubernetes or approle
-	// ensure that authPath is free of leading and trailing "/" so we can get the right slice
-	authPath := strings.Split(strings.Trim(vaultAuthPath, "/"), "/")
-	if len(authPath) != 2 {
-		return fmt.Errorf("incorrect number of slice found in auth path: %d", len(authPath))
-	}
-	method := authPath[1]
-
-	// Initialise a request body variable with the type of []byte
-	var requestBody []byte
-
-	// Ensure the token in a slice is not null before doing the switch statement
-	// if the token in slice is null, return an error
-	if method != "" {
-		switch method {
-		case "kubernetes":
-			kubernetesData, err := json.Marshal(map[string]string{
-				"jwt":  string(vaultToken),
-				"role": appRoleName,
-			})
+// Sync the secrets of the options after every interval until the context is done
+func (s *Syncer) watch(ctx context.Context, interval time.Duration) error {
+	vars := s.opts.Secrets
+	method, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	cache := newVersionCache()
+	var token auth.Token
+	var expiry time.Time
+	defer func() {
+		s.revokeToken(s.authMethodName(), token.ClientToken, vars)
+	}()
+	for {
+		runCtx, cancel := runContext(ctx, s.opts.Timeout)
+		// Login again once two thirds of the ttl are gone, a token without ttl never expires
+		// The old token is revoked first so a live token isn't left behind at each login
+		if token.ClientToken != "" && token.TTL > 0 && time.Now().After(expiry) {
+			s.revokeToken(s.authMethodName(), token.ClientToken, vars)
+			token = auth.Token{}
+		}
+		if token.ClientToken == "" {
+			token, err = method.Login(runCtx)
 			if err != nil {
-				return fmt.Errorf("failed to construct json payload for auth method: %s", method)
+				logger.Errorf("login failed, trying again in %s: %s", interval, err)
+			} else {
+				expiry = time.Now().Add(token.TTL * 2 / 3)
 			}
-			requestBody = kubernetesData
-		case "approle":
-			approleData, err := json.Marshal(map[string]string{
-				"secret_id": string(vaultToken),
-				"role_id":   appRoleName,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to construct json payload for auth method: %s", method)
+		}
+		if token.ClientToken != "" {
+			if _, err := s.syncSecrets(runCtx, vars, token.ClientToken, cache, s.opts.TrackVersions); err != nil {
+				logger.Error(err)
+				// A failed path keeps the token, only one vault doesn't accept anymore is replaced at the next sync
+				if s.tokenRejected(runCtx, token.ClientToken) {
+					logger.Warn("the token was rejected by vault, logging in again at the next sync")
+					token = auth.Token{}
+				}
 			}
-			requestBody = approleData
-		default:
-			return fmt.Errorf("no matching auth method found ")
 		}
-	} else {
-		return fmt.Errorf("variable VAULT_AUTH_PATH was not set")
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			logger.Infof("stopping: %s", ctx.Err())
+			return nil
+		case <-time.After(interval):
+		}
 	}
+}
 
-	// Additional check the endpoint of the vault
-	// ATLS-618 Add poll of vault endpoint/sleep in gopher startup
-	err = client.GetStatus(vaultAddress, VaultHealthEndpoint)
+// WatchTrackVersions tells if the long-running mode tracks the versions of the paths
+// Polling is what the versions are for, it's on unless VAULT_TRACK_VERSIONS turns it off or the flag is given
+func WatchTrackVersions(flagSet bool) bool {
+	if flagSet {
+		return vaultTrackVersions
+	}
+	return getEnvBool("VAULT_TRACK_VERSIONS", true)
+}
+
+// Return the secrets of SECRET_OBJECT
+func secretObject() (map[string]*models.SecretSpec, error) {
+	// ATLS-627 support for secret segregation
+	cm := []byte(secretObjectJson)
+	if SecretObjectFile != "" {
+		var err error
+		cm, err = ioutil.ReadFile(SecretObjectFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the secret object file: %s", err)
+		}
+	}
+
+	var vars map[string]*models.SecretSpec
+
+	if err := json.Unmarshal([]byte(cm), &vars); err != nil {
+		return nil, fmt.Errorf("error processing the map env: %s", err)
+	}
+	return vars, nil
+}
+
+// Wait for vault to be up and return the auth method to login with
+func (s *Syncer) connect(ctx context.Context) (auth.AuthMethod, error) {
+	client, err := s.vaultClient()
 	if err != nil {
-		return fmt.Errorf("%s", err)
+		return nil, err
 	}
-	// Initialise a struct to get the full path of the authentication url in vault
-	// we use then the mounted token / service account token
-	loginUrl := &RequestUrl{
-		BaseUrl: vaultAddress,
-		Path:    vaultAuthPath,
+
+	// We get the client token to be used to get the secrets
+	// The auth method is chosen explicitly with VAULT_AUTH_METHOD or taken from the legacy auth/<method> path
+	method, err := s.authMethod()
+	if err != nil {
+		return nil, err
 	}
-	loginAuthPath := loginUrl.GetPath("login")
-	clientToken, err := client.GetClientToken(requestBody, loginAuthPath, vaultNamespace)
+
+	// Additional check the endpoint of the vault
+	// ATLS-618 Add poll of vault endpoint/sleep in gopher startup
+	err = client.GetStatus(ctx, s.opts.VaultAddress, VaultHealthEndpoint)
 	if err != nil {
-		return fmt.Errorf("error encountered while authenticating to vault: %s", err)
+		return nil, fmt.Errorf("%s", err)
 	}
+	return method, nil
+}
 
-	// ATLS-627 support for secret segregation
-	cm := getEnv("SECRET_OBJECT")
+// KubeOptions tells how to reach the kubernetes api
+type KubeOptions struct {
+	// Path of the kubeconfig, KUBECONFIG list syntax is supported
+	// The mounted service account is used when it's empty and we are running in a cluster
+	Kubeconfig string
+	// Context of the kubeconfig, the current-context is used when it's empty
+	Context string
+	// Namespace that takes over the one of the context or the service account
+	Namespace string
+}
 
-	var vars map[string][]string
+// Kube is set from the env and can be overridden by the command line flags
+var Kube = KubeOptions{
+	Kubeconfig: os.Getenv("KUBECONFIG"),
+	Context:    os.Getenv("KUBE_CONTEXT"),
+	Namespace:  os.Getenv("KUBE_NAMESPACE"),
+}
 
-	if err := json.Unmarshal([]byte(cm), &vars); err != nil {
-		return fmt.Errorf("error processing the map env: %s", err)
-	}
+// Return the kubernetes cluster the secrets are written to
+// A kubeconfig takes precedence, the mounted service account is used when running inside the cluster
+// and the default kubeconfig of kubectl is used as the last resort
+func (s *Syncer) kubernetesCluster() (*models.Cluster, error) {
+	var cluster *models.Cluster
+	var err error
+	kube := s.opts.Kube
 
-	for key, values := range vars {
-		// We use the temporary token that vault server provided to access the secret
-		// Client token has ttl equals to 900second
-		dataUrl := &RequestUrl{
-			BaseUrl: vaultAddress,
-			Path:    vaultSecretPath,
+	// A kubernetes client given to the Syncer knows how to reach its cluster, only the namespace is needed
+	if s.opts.Kubernetes != nil && kube.Kubeconfig == "" {
+		if kube.Namespace == "" {
+			return nil, fmt.Errorf("no namespace found, set one in the options of the syncer")
 		}
-		// Instantiate a map[string]interface{} type
-		// Placeholder of the kv secret we fetch from the vault
-		data := make(map[string]interface{})
+		return &models.Cluster{Namespace: kube.Namespace}, nil
+	}
 
-		for _, value := range values {
-			secretPath := dataUrl.GetPath(strings.TrimSpace(string(value)))
-			payload, err := client.GetData(clientToken.(string), secretPath, vaultNamespace)
-			if err != nil {
-				return fmt.Errorf("encountered error while fetching secrets from vault: %s", err)
-			}
-			// We safeguard the runtime here
-			// Sometimes a call to secret returns an empty object
-			if len(payload) != 0 {
-				for key, secret := range payload {
-					data[key] = secret
-				}
-			}
+	path := kube.Kubeconfig
+	if path == "" && kubernetesServiceHost == "" {
+		path = kubeconfig.DefaultPath()
+	}
+	if path != "" {
+		cluster, err = kubeconfig.Load(path, kube.Context)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load kubeconfig error: %s", err)
 		}
-		if len(data) != 0 {
-			if err := create(data, objectName, strings.TrimSpace(key)); err != nil {
-				return fmt.Errorf("kubernetes secret cannot be created error: %s", err)
-			}
+	} else {
+		cluster, err = inCluster()
+		if err != nil {
+			return nil, err
 		}
 	}
-	return nil
+
+	if kube.Namespace != "" {
+		cluster.Namespace = kube.Namespace
+	}
+	if cluster.Namespace == "" {
+		return nil, fmt.Errorf("no namespace found, set one in the kubeconfig context or with --namespace")
+	}
+	return cluster, nil
 }
 
-// Handler to create the object
-// ATLS-627 creating multiple object
-func create(m map[string]interface{}, objectName, secretObjectName string) error {
-	var client apis.Client
+// Read the token, namespace and ca certificate of the service account from the mounted volume
+func inCluster() (*models.Cluster, error) {
 	// Read the token from the mount volume and parse it
 	serviceAcctToken, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", ServiceAccountPath, "token"))
 	if err != nil {
-		return fmt.Errorf("cannot read kubernetes token error: %v", err)
+		return nil, fmt.Errorf("cannot read kubernetes token error: %v", err)
 	}
 	// Read the token from the mount volume and parse it
 	namespace, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", ServiceAccountPath, "namespace"))
 	if err != nil {
-		return fmt.Errorf("cannot read kuberneres namespace error: %v", err)
+		return nil, fmt.Errorf("cannot read kuberneres namespace error: %v", err)
 	}
 	// Read the the token and return a byte
 	cacrt, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", ServiceAccountPath, "ca.crt"))
 	if err != nil {
-		return fmt.Errorf("cannot read ca certificate error %v", err)
+		return nil, fmt.Errorf("cannot read ca certificate error %v", err)
 	}
-
-	if len(m) != 0 {
-		// We get that secrets payload and feed it to Object() function and return the json formatted secret object manifest for kubernetes api
-		object, err := object(secretObjectName, string(namespace), utils.EncodeValue(m))
-		if err != nil {
-			return fmt.Errorf("encountered error while constructing kubernetes object: %s", err)
-		}
-		// This call the api that checks the object in kubernetes api
-		// Depending on the return values, the api call to create the object will switch between POST and PUT method
-		status, err := client.Get(string(serviceAcctToken), kubernetesServiceHost,
-			string(namespace), objectName, secretObjectName, cacrt)
-		if err != nil {
-			return fmt.Errorf("encountered error while verifying secret object in kubernetes: %s", err)
-		}
-		// Create the object to kubernetes api
-		// Object would be created if it's not present or updated if exist, the status variable will define how the object will be created
-		resp, err := client.Create(string(serviceAcctToken), kubernetesServiceHost,
-			string(namespace), objectName, secretObjectName, status.(int), cacrt, object)
-		if err != nil {
-			return fmt.Errorf("encountered error while creating the kubernetes secret object: %s", err)
-		}
-		// Handle the resp coming from kubernetes api
-		// loop into the struct and check if the "code" key is present in the struct
-		// pretty much not a good way to handle it but kubernetes returns a nested data structure
-		for key, value := range resp {
-			if key == "code" {
-				return fmt.Errorf("error creating secret with repond code: %v\nErrorMessage: %v", value, resp["message"])
-			}
-		}
+	// The api server doesn't have to listen on 443
+	port := kubernetesServicePort
+	if port == "" {
+		port = "443"
 	}
-	return nil
+	return &models.Cluster{
+		Server:    "https://" + net.JoinHostPort(kubernetesServiceHost, port),
+		Token:     string(serviceAcctToken),
+		CA:        cacrt,
+		Namespace: string(namespace),
+	}, nil
 }
-
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+const (
+	// What the import does with a key that is already in vault with another value
+	// skip keeps the value of vault, diff lists the keys that differ and doesn't write the secret
+	ImportSkip = "skip"
+	ImportDiff = "diff"
+
+	// Path a secret is imported to when no template is given
+	DefaultImportPath = "{{ .Namespace }}/{{ .Name }}"
+)
+
+// ImportOptions tells which kubernetes secrets are imported and where they are written in vault
+type ImportOptions struct {
+	// Names of the secrets to import
+	Names []string
+	// Label selector of the secrets to import, on top of the names
+	Selector string
+	// Namespace the secrets are read from, the one of the cluster when it's empty
+	Namespace string
+	// Template of the kv path under VAULT_SECRET_PATH, it's given the Namespace and the Name of the secret
+	Path string
+	// One of ImportSkip or ImportDiff, skip by default
+	Existing string
+}
+
+// importPath is what the path template of the import is given
+type importPath struct {
+	Namespace string
+	Name      string
+}
+
+// Import copies existing kubernetes secrets into vault and prints the SECRET_OBJECT that syncs them back
+// A value in vault is never overwritten, the write uses check-and-set so a write made in between wins
+func Import(ctx context.Context, objectName string, opts ImportOptions, out io.Writer) error {
+	// The secrets to import are in kubernetes, SECRET_OBJECT is what is printed
+	syncOpts := envOptions()
+	syncOpts.ObjectName = objectName
+	s := NewSyncer(syncOpts)
+
+	ctx, cancel := runContext(ctx, syncOpts.Timeout)
+	defer cancel()
+	method, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := method.Login(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.revokeToken(s.authMethodName(), token.ClientToken, nil)
+	return s.importSecrets(ctx, opts, token.ClientToken, out)
+}
+
+func (s *Syncer) importSecrets(ctx context.Context, opts ImportOptions, clientToken string, out io.Writer) error {
+	objectName := s.opts.ObjectName
+	switch opts.Existing {
+	case "":
+		opts.Existing = ImportSkip
+	case ImportSkip, ImportDiff:
+	default:
+		return fmt.Errorf("existing should be %s or %s, got %s", ImportSkip, ImportDiff, opts.Existing)
+	}
+	if len(opts.Names) == 0 && opts.Selector == "" {
+		return fmt.Errorf("no secret to import, give the names or a label selector")
+	}
+	if opts.Path == "" {
+		opts.Path = DefaultImportPath
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(opts.Path)
+	if err != nil {
+		return fmt.Errorf("invalid path template %s: %s", opts.Path, err)
+	}
+
+	cluster, err := s.kubernetesCluster()
+	if err != nil {
+		return err
+	}
+	ns := opts.Namespace
+	if ns == "" {
+		ns = cluster.Namespace
+	}
+	secrets, err := s.importedSecrets(ctx, cluster, ns, objectName, opts)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.vaultClient()
+	if err != nil {
+		return err
+	}
+	dataUrl := &RequestUrl{
+		BaseUrl: s.opts.VaultAddress,
+		Path:    s.opts.SecretPath,
+	}
+
+	config := make(map[string]*models.SecretSpec)
+	var failed []string
+	for _, secret := range secrets {
+		meta, _ := secret["metadata"].(map[string]interface{})
+		name, _ := meta["name"].(string)
+		secretType, _ := secret["type"].(string)
+		if secretType == ServiceAccountTokenType {
+			failed = append(failed, fmt.Sprintf("%s: secrets of type %s are issued by kubernetes, they can't be synced from vault", name, secretType))
+			continue
+		}
+		data := decodeData(secret)
+		if data == nil {
+			failed = append(failed, fmt.Sprintf("%s: data is not base64 encoded", name))
+			continue
+		}
+		// Vault stores json strings, binary values would come back with other bytes
+		// The whole secret is left out, syncing it back without these keys would drop them
+		if keys := binaryKeys(data); len(keys) != 0 {
+			failed = append(failed, fmt.Sprintf("%s: values of %s are not valid utf-8, they can't be stored in vault as they are",
+				name, strings.Join(keys, ", ")))
+			continue
+		}
+
+		var b strings.Builder
+		if err := tmpl.Execute(&b, importPath{Namespace: ns, Name: name}); err != nil {
+			return fmt.Errorf("cannot render the path of secret %s: %s", name, err)
+		}
+		path := strings.Trim(b.String(), "/")
+
+		if err := importSecret(ctx, client, clientToken, dataUrl.GetPath(path), s.opts.VaultNamespace, name, path, data, opts.Existing); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+
+		spec := &models.SecretSpec{Paths: []models.PathSpec{{Path: path}}}
+		// The secret is synced back with its own type, ex. kubernetes.io/tls
+		if secretType != "Opaque" {
+			spec.Type = secretType
+		}
+		// The secret is written back where it was read from
+		if ns != cluster.Namespace {
+			spec.Namespace = ns
+		}
+		config[name] = spec
+	}
+
+	if len(config) != 0 {
+		// Maps are marshalled with sorted keys so the output is stable
+		b, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(b))
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("%d secret(s) failed to import:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// Return the secrets given by name and the ones matching the selector, sorted by name without duplicates
+func (s *Syncer) importedSecrets(ctx context.Context, cluster *models.Cluster, ns, objectName string, opts ImportOptions) ([]map[string]interface{}, error) {
+	client := s.kubernetesClient()
+	byName := make(map[string]map[string]interface{})
+	for _, name := range opts.Names {
+		status, secret, err := client.GetSecret(ctx, cluster, ns, objectName, name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read secret %s: %s", name, err)
+		}
+		if status != 200 {
+			return nil, fmt.Errorf("cannot read secret %s, kubernetes api responded with: %d", name, status)
+		}
+		byName[name] = secret
+	}
+	if opts.Selector != "" {
+		secrets, err := client.ListSecrets(ctx, cluster, ns, objectName, opts.Selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range secrets {
+			meta, _ := secret["metadata"].(map[string]interface{})
+			name, _ := meta["name"].(string)
+			byName[name] = secret
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, byName[name])
+	}
+	return ret, nil
+}
+
+// Return the sorted keys whose values are not valid utf-8, ex. a keystore or a der certificate
+func binaryKeys(data map[string]interface{}) []string {
+	var ret []string
+	for key, value := range data {
+		if s, _ := value.(string); !utf8.ValidString(s) {
+			ret = append(ret, key)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Write the keys of a secret that are missing in vault to its kv path
+func importSecret(ctx context.Context, client Vault, clientToken, url, namespace, name, path string, data map[string]interface{}, existing string) error {
+	current, version, err := client.ReadKV(ctx, clientToken, url, namespace)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %s", path, err)
+	}
+	if current == nil {
+		current = make(map[string]interface{})
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var added, differ []string
+	for _, key := range keys {
+		value, ok := current[key]
+		if !ok {
+			current[key] = data[key]
+			added = append(added, key)
+			continue
+		}
+		// Only the keys are listed, the values stay out of the logs
+		if !reflect.DeepEqual(value, data[key]) {
+			differ = append(differ, key)
+		}
+	}
+	if len(differ) != 0 {
+		if existing == ImportDiff {
+			return fmt.Errorf("%s already has different values for %s", path, strings.Join(differ, ", "))
+		}
+		logger.Warnf("keeping the values of %s in %s, they differ from secret %s", strings.Join(differ, ", "), path, name)
+	}
+	if len(added) == 0 {
+		logger.Infof("secret %s is already in %s", name, path)
+		return nil
+	}
+	if err := client.WriteKV(ctx, clientToken, url, namespace, current, version); err != nil {
+		return fmt.Errorf("cannot write %s to %s: %s", strings.Join(added, ", "), path, err)
+	}
+	logger.Infof("imported %s of secret %s to %s", strings.Join(added, ", "), name, path)
+	return nil
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func Test_importSecrets(t *testing.T) {
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+	kube.add("sit-sre", "app-sit-secret", map[string]interface{}{"app": "billing"}, map[string]interface{}{"username": "app", "password": "hunter2"})
+	kube.add("sit-sre", "api-sit-secret", map[string]interface{}{"app": "billing"}, map[string]interface{}{"token": "abc"})
+	kube.add("sit-sre", "other-sit-secret", nil, map[string]interface{}{"token": "def"})
+	kube.add("sit-sre", "tls-sit-secret", nil, map[string]interface{}{"tls.crt": "cert", "tls.key": "key"})
+	kube.object("sit-sre", "tls-sit-secret")["type"] = "kubernetes.io/tls"
+	kube.add("sit-sre", "default-token", nil, map[string]interface{}{"token": "issued"})
+	kube.object("sit-sre", "default-token")["type"] = ServiceAccountTokenType
+	// Start of a der certificate, it's not valid utf-8
+	kube.add("sit-sre", "keystore-sit-secret", nil, map[string]interface{}{"keystore.p12": "\x30\x82\x0a\xff", "password": "changeit"})
+
+	tests := []struct {
+		name       string
+		opts       ImportOptions
+		vault      map[string]map[string]interface{}
+		wantWrites []string
+		want       map[string]map[string]interface{}
+		wantConfig map[string]*models.SecretSpec
+		// Types of the secrets once the printed config is synced back
+		wantTypes map[string]string
+		wantErr   string
+	}{
+		{
+			name:       "selector",
+			opts:       ImportOptions{Selector: "app=billing", Path: "billing/{{ .Name }}"},
+			wantWrites: []string{"billing/api-sit-secret cas=0", "billing/app-sit-secret cas=0"},
+			want: map[string]map[string]interface{}{
+				"billing/api-sit-secret": {"token": "abc"},
+				"billing/app-sit-secret": {"username": "app", "password": "hunter2"},
+			},
+			wantConfig: map[string]*models.SecretSpec{
+				"api-sit-secret": {Paths: []models.PathSpec{{Path: "billing/api-sit-secret"}}},
+				"app-sit-secret": {Paths: []models.PathSpec{{Path: "billing/app-sit-secret"}}},
+			},
+		},
+		{
+			name:       "skip-existing",
+			opts:       ImportOptions{Names: []string{"app-sit-secret"}},
+			vault:      map[string]map[string]interface{}{"sit-sre/app-sit-secret": {"password": "from-vault"}},
+			wantWrites: []string{"sit-sre/app-sit-secret cas=1"},
+			want: map[string]map[string]interface{}{
+				"sit-sre/app-sit-secret": {"username": "app", "password": "from-vault"},
+			},
+			wantConfig: map[string]*models.SecretSpec{
+				"app-sit-secret": {Paths: []models.PathSpec{{Path: "sit-sre/app-sit-secret"}}},
+			},
+		},
+		{
+			name:       "typed",
+			opts:       ImportOptions{Names: []string{"tls-sit-secret"}},
+			wantWrites: []string{"sit-sre/tls-sit-secret cas=0"},
+			want: map[string]map[string]interface{}{
+				"sit-sre/tls-sit-secret": {"tls.crt": "cert", "tls.key": "key"},
+			},
+			wantConfig: map[string]*models.SecretSpec{
+				"tls-sit-secret": {Paths: []models.PathSpec{{Path: "sit-sre/tls-sit-secret"}}, Type: "kubernetes.io/tls"},
+			},
+			wantTypes: map[string]string{"tls-sit-secret": "kubernetes.io/tls"},
+		},
+		{
+			name:    "service-account-token",
+			opts:    ImportOptions{Names: []string{"default-token"}},
+			wantErr: "are issued by kubernetes",
+		},
+		{
+			name:    "binary",
+			opts:    ImportOptions{Names: []string{"keystore-sit-secret"}},
+			wantErr: "values of keystore.p12 are not valid utf-8",
+		},
+		{
+			name:    "diff-existing",
+			opts:    ImportOptions{Names: []string{"app-sit-secret"}, Existing: ImportDiff},
+			vault:   map[string]map[string]interface{}{"sit-sre/app-sit-secret": {"password": "from-vault"}},
+			wantErr: "already has different values for password",
+		},
+		{
+			name:    "missing",
+			opts:    ImportOptions{Names: []string{"missing-sit-secret"}},
+			wantErr: "cannot read secret missing-sit-secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := &fakeKV{data: tt.vault, versions: make(map[string]int)}
+			if kv.data == nil {
+				kv.data = make(map[string]map[string]interface{})
+			}
+			for path := range kv.data {
+				kv.versions[path] = 1
+			}
+			vault := httptest.NewServer(kv)
+			defer vault.Close()
+			s := NewSyncer(kube.options(vault.URL))
+
+			var out bytes.Buffer
+			err := s.importSecrets(context.Background(), tt.opts, "token", &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("importSecrets() error = %v, want %v", err, tt.wantErr)
+				}
+				if len(kv.writes) != 0 {
+					t.Errorf("importSecrets() vault writes = %v, want none", kv.writes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("importSecrets() error = %v", err)
+			}
+			if !reflect.DeepEqual(kv.writes, tt.wantWrites) {
+				t.Errorf("importSecrets() vault writes = %v, want %v", kv.writes, tt.wantWrites)
+			}
+			for path, want := range tt.want {
+				if !reflect.DeepEqual(kv.data[path], want) {
+					t.Errorf("importSecrets() %s = %v, want %v", path, kv.data[path], want)
+				}
+			}
+
+			// What is printed is a SECRET_OBJECT that syncs the secrets back
+			var config map[string]*models.SecretSpec
+			if err := json.Unmarshal(out.Bytes(), &config); err != nil {
+				t.Fatalf("importSecrets() printed %s: %v", out.String(), err)
+			}
+			if !reflect.DeepEqual(config, tt.wantConfig) {
+				t.Errorf("importSecrets() config = %s, want %v", out.String(), tt.wantConfig)
+			}
+			if tt.wantTypes == nil {
+				return
+			}
+			for name := range config {
+				kube.remove("sit-sre", name)
+			}
+			if _, err := s.syncSecrets(context.Background(), config, "token", nil, false); err != nil {
+				t.Fatalf("syncSecrets() error = %v", err)
+			}
+			for name, want := range tt.wantTypes {
+				if got := kube.object("sit-sre", name)["type"]; got != want {
+					t.Errorf("syncSecrets() type of %s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
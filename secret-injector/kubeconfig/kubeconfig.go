@@ -0,0 +1,337 @@
+// Package kubeconfig reads the kubeconfig file so the app can run outside of the cluster
+// Only the subset needed to reach the api server is supported: contexts, ca, bearer tokens,
+// client certificates and exec credential plugins
+package kubeconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the part of the kubeconfig file we understand
+type Config struct {
+	CurrentContext string         `yaml:"current-context"`
+	Clusters       []NamedCluster `yaml:"clusters"`
+	Contexts       []NamedContext `yaml:"contexts"`
+	Users          []NamedUser    `yaml:"users"`
+}
+
+type NamedCluster struct {
+	Name    string  `yaml:"name"`
+	Cluster Cluster `yaml:"cluster"`
+}
+
+type Cluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+}
+
+type NamedContext struct {
+	Name    string  `yaml:"name"`
+	Context Context `yaml:"context"`
+}
+
+type Context struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace"`
+}
+
+type NamedUser struct {
+	Name string `yaml:"name"`
+	User User   `yaml:"user"`
+}
+
+type User struct {
+	Token                 string      `yaml:"token"`
+	TokenFile             string      `yaml:"tokenFile"`
+	ClientCertificate     string      `yaml:"client-certificate"`
+	ClientCertificateData string      `yaml:"client-certificate-data"`
+	ClientKey             string      `yaml:"client-key"`
+	ClientKeyData         string      `yaml:"client-key-data"`
+	Exec                  *ExecConfig `yaml:"exec"`
+}
+
+// ExecConfig is the exec credential plugin, ex. aws eks get-token or gke-gcloud-auth-plugin
+type ExecConfig struct {
+	APIVersion  string       `yaml:"apiVersion"`
+	Command     string       `yaml:"command"`
+	Args        []string     `yaml:"args"`
+	Env         []ExecEnvVar `yaml:"env"`
+	InstallHint string       `yaml:"installHint"`
+}
+
+type ExecEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// ExecCredential is what the exec plugin prints on stdout
+type ExecCredential struct {
+	Status struct {
+		Token                 string `json:"token"`
+		ClientCertificateData string `json:"clientCertificateData"`
+		ClientKeyData         string `json:"clientKeyData"`
+	} `json:"status"`
+}
+
+// DefaultPath is the kubeconfig used by kubectl when KUBECONFIG is not set
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// Load reads the kubeconfig and returns the cluster of the given context
+// paths is a list separated the same way as KUBECONFIG, the first file that defines a value wins
+// An empty context uses the current-context of the kubeconfig
+func Load(paths, context string) (*models.Cluster, error) {
+	config, err := read(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	if context == "" {
+		context = config.CurrentContext
+	}
+	if context == "" {
+		return nil, fmt.Errorf("no context given and kubeconfig has no current-context")
+	}
+
+	var ctx *Context
+	for i := range config.Contexts {
+		if config.Contexts[i].Name == context {
+			ctx = &config.Contexts[i].Context
+			break
+		}
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("context %s not found in kubeconfig", context)
+	}
+
+	var cluster *Cluster
+	for i := range config.Clusters {
+		if config.Clusters[i].Name == ctx.Cluster {
+			cluster = &config.Clusters[i].Cluster
+			break
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster %s of context %s not found in kubeconfig", ctx.Cluster, context)
+	}
+
+	// A context without a user is valid, ex. a local proxy that doesn't need credentials
+	var user User
+	for i := range config.Users {
+		if config.Users[i].Name == ctx.User {
+			user = config.Users[i].User
+			break
+		}
+	}
+
+	ret := &models.Cluster{
+		Server:    strings.TrimRight(cluster.Server, "/"),
+		Insecure:  cluster.InsecureSkipTLSVerify,
+		Namespace: ctx.Namespace,
+	}
+	if ret.CA, err = dataOrFile(cluster.CertificateAuthorityData, cluster.CertificateAuthority); err != nil {
+		return nil, fmt.Errorf("cannot read certificate authority of cluster %s: %s", ctx.Cluster, err)
+	}
+	if ret.CertData, err = dataOrFile(user.ClientCertificateData, user.ClientCertificate); err != nil {
+		return nil, fmt.Errorf("cannot read client certificate of user %s: %s", ctx.User, err)
+	}
+	if ret.KeyData, err = dataOrFile(user.ClientKeyData, user.ClientKey); err != nil {
+		return nil, fmt.Errorf("cannot read client key of user %s: %s", ctx.User, err)
+	}
+
+	ret.Token = user.Token
+	if ret.Token == "" && user.TokenFile != "" {
+		token, err := ioutil.ReadFile(user.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read token file of user %s: %s", ctx.User, err)
+		}
+		ret.Token = strings.TrimSpace(string(token))
+	}
+
+	// The exec plugin fills whatever the static configuration didn't provide
+	if user.Exec != nil {
+		cred, err := runExec(user.Exec)
+		if err != nil {
+			return nil, fmt.Errorf("exec credential plugin of user %s failed: %s", ctx.User, err)
+		}
+		if ret.Token == "" {
+			ret.Token = cred.Status.Token
+		}
+		if len(ret.CertData) == 0 && cred.Status.ClientCertificateData != "" {
+			ret.CertData = []byte(cred.Status.ClientCertificateData)
+			ret.KeyData = []byte(cred.Status.ClientKeyData)
+		}
+	}
+
+	return ret, nil
+}
+
+// Read and merge every kubeconfig file of the list
+// Relative file references are resolved against the directory of the file they are defined in
+func read(paths string) (*Config, error) {
+	merged := &Config{}
+	found := false
+
+	for _, path := range filepath.SplitList(paths) {
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			// Same as kubectl, a missing file in the list is not an error
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("cannot read kubeconfig %s: %s", path, err)
+		}
+		var config Config
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("cannot parse kubeconfig %s: %s", path, err)
+		}
+		found = true
+		resolve(&config, filepath.Dir(path))
+
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = config.CurrentContext
+		}
+		for _, c := range config.Clusters {
+			if !hasCluster(merged, c.Name) {
+				merged.Clusters = append(merged.Clusters, c)
+			}
+		}
+		for _, c := range config.Contexts {
+			if !hasContext(merged, c.Name) {
+				merged.Contexts = append(merged.Contexts, c)
+			}
+		}
+		for _, u := range config.Users {
+			if !hasUser(merged, u.Name) {
+				merged.Users = append(merged.Users, u)
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no kubeconfig found in %s", paths)
+	}
+	return merged, nil
+}
+
+// Make the file references of the kubeconfig absolute
+func resolve(config *Config, dir string) {
+	abs := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	for i := range config.Clusters {
+		config.Clusters[i].Cluster.CertificateAuthority = abs(config.Clusters[i].Cluster.CertificateAuthority)
+	}
+	for i := range config.Users {
+		u := &config.Users[i].User
+		u.ClientCertificate = abs(u.ClientCertificate)
+		u.ClientKey = abs(u.ClientKey)
+		u.TokenFile = abs(u.TokenFile)
+		// Only a command with a path separator is relative to the kubeconfig, otherwise it's looked up in PATH
+		if u.Exec != nil && strings.ContainsRune(u.Exec.Command, filepath.Separator) {
+			u.Exec.Command = abs(u.Exec.Command)
+		}
+	}
+}
+
+func hasCluster(config *Config, name string) bool {
+	for _, c := range config.Clusters {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasContext(config *Config, name string) bool {
+	for _, c := range config.Contexts {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasUser(config *Config, name string) bool {
+	for _, u := range config.Users {
+		if u.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the base64 decoded data or the content of the file if there's no data
+func dataOrFile(data, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// Run the exec credential plugin and return the credential it printed
+func runExec(e *ExecConfig) (*ExecCredential, error) {
+	// The plugin needs to know it can't prompt anyone, we are running in a job or a pipeline
+	info, err := json.Marshal(map[string]interface{}{
+		"apiVersion": e.APIVersion,
+		"kind":       "ExecCredential",
+		"spec": map[string]interface{}{
+			"interactive": false,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(e.Command, e.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range e.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Name, env.Value))
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("KUBERNETES_EXEC_INFO=%s", info))
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if e.InstallHint != "" {
+			return nil, fmt.Errorf("%s\n%s", err, e.InstallHint)
+		}
+		return nil, err
+	}
+
+	var cred ExecCredential
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return nil, fmt.Errorf("cannot parse the output of %s: %s", e.Command, err)
+	}
+	if cred.Status.Token == "" && cred.Status.ClientCertificateData == "" {
+		return nil, fmt.Errorf("%s returned neither a token nor a client certificate", e.Command)
+	}
+	return &cred, nil
+}
@@ -0,0 +1,155 @@
+package kubeconfig
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+const testConfig = `apiVersion: v1
+kind: Config
+current-context: sit
+clusters:
+- name: sit
+  cluster:
+    server: https://sit.example.com:6443/
+    certificate-authority-data: %s
+- name: lab
+  cluster:
+    server: https://lab.example.com
+    certificate-authority: ca.crt
+    insecure-skip-tls-verify: true
+contexts:
+- name: sit
+  context:
+    cluster: sit
+    user: token
+    namespace: sit-sre
+- name: lab
+  context:
+    cluster: lab
+    user: cert
+- name: exec
+  context:
+    cluster: sit
+    user: exec
+users:
+- name: token
+  user:
+    token: secret-token
+- name: cert
+  user:
+    client-certificate: client.crt
+    client-key-data: %s
+- name: exec
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: sh
+      args:
+      - -c
+      - 'echo "{\"status\":{\"token\":\"$GOPHER_TOKEN\"}}"'
+      env:
+      - name: GOPHER_TOKEN
+        value: exec-token
+`
+
+func writeFile(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b64 := base64.StdEncoding.EncodeToString
+	config := filepath.Join(dir, "config")
+	writeFile(t, config, fmt.Sprintf(testConfig, b64([]byte("sit-ca")), b64([]byte("client-key"))))
+	writeFile(t, filepath.Join(dir, "ca.crt"), "lab-ca")
+	writeFile(t, filepath.Join(dir, "client.crt"), "client-cert")
+
+	tests := []struct {
+		name    string
+		paths   string
+		context string
+		want    *models.Cluster
+		wantErr bool
+	}{
+		{
+			name:  "current-context",
+			paths: config,
+			want: &models.Cluster{
+				Server:    "https://sit.example.com:6443",
+				Token:     "secret-token",
+				CA:        []byte("sit-ca"),
+				Namespace: "sit-sre",
+			},
+		},
+		{
+			name:    "client-certificate",
+			paths:   config,
+			context: "lab",
+			want: &models.Cluster{
+				Server:   "https://lab.example.com",
+				CA:       []byte("lab-ca"),
+				CertData: []byte("client-cert"),
+				KeyData:  []byte("client-key"),
+				Insecure: true,
+			},
+		},
+		{
+			name:    "exec-plugin",
+			paths:   config,
+			context: "exec",
+			want: &models.Cluster{
+				Server: "https://sit.example.com:6443",
+				Token:  "exec-token",
+				CA:     []byte("sit-ca"),
+			},
+		},
+		{
+			name:    "missing-file-in-list",
+			paths:   filepath.Join(dir, "missing") + string(filepath.ListSeparator) + config,
+			context: "sit",
+			want: &models.Cluster{
+				Server:    "https://sit.example.com:6443",
+				Token:     "secret-token",
+				CA:        []byte("sit-ca"),
+				Namespace: "sit-sre",
+			},
+		},
+		{
+			name:    "unknown-context",
+			paths:   config,
+			context: "prod",
+			wantErr: true,
+		},
+		{
+			name:    "no-kubeconfig",
+			paths:   filepath.Join(dir, "missing"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.paths, tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
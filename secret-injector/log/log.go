@@ -45,7 +45,8 @@ func NewLogger() *GopherLogger {
 		},
 		TimestampFormat: time.RFC3339,
 	}
-	gopherLogger.Out = os.Stdout
+	// Stdout is left to the output of the app, ex. the json report, so it can be piped
+	gopherLogger.Out = os.Stderr
 
 	return gopherLogger
 }
@@ -103,8 +104,9 @@ func (g *GopherLogger) LogGopher(resp *http.Response, req *http.Request) {
 }
 
 // Log 5xx status code
+// The caller decides if the failure is fatal so the other requests can still carry on
 func (g *GopherLogger) log5xx(s string) {
-	g.Fatal(s)
+	g.Error(s)
 }
 
 // Log 4xx status code
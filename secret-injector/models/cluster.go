@@ -0,0 +1,19 @@
+package models
+
+// Cluster holds everything needed to talk to the kubernetes api
+// It's either built from the mounted service account or from a kubeconfig
+type Cluster struct {
+	// Address of the api server including the scheme and port, ex. https://10.0.0.1:443
+	Server string
+	// Bearer token, empty when a client certificate is used
+	Token string
+	// PEM encoded ca certificate of the api server
+	CA []byte
+	// PEM encoded client certificate and key
+	CertData []byte
+	KeyData  []byte
+	// Skip the verification of the api server certificate
+	Insecure bool
+	// Namespace the secrets are written to
+	Namespace string
+}
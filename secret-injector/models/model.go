@@ -29,3 +29,34 @@ type Payload struct {
 	} `json:"auth"`
 	Errors []string `json:"errors,omitempty"`
 }
+
+// TokenInfo is the payload of auth/token/lookup-self
+type TokenInfo struct {
+	Data struct {
+		Accessor    string   `json:"accessor"`
+		DisplayName string   `json:"display_name"`
+		Policies    []string `json:"policies"`
+		TTL         int      `json:"ttl"`
+		ExpireTime  string   `json:"expire_time"`
+		Renewable   bool     `json:"renewable"`
+	} `json:"data"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Secret is the generic payload of vault, the shape of data depends on the endpoint
+type Secret struct {
+	RequestId     string                 `json:"request_id"`
+	LeaseId       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Warnings      []string               `json:"warnings"`
+	Errors        []string               `json:"errors,omitempty"`
+}
+
+// Version is where a kv v2 secret is at, from its metadata
+// updated_time tells a version apart from the one that was destroyed and written again
+type Version struct {
+	Version     int    `json:"version"`
+	UpdatedTime string `json:"updated_time"`
+}
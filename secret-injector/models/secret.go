@@ -0,0 +1,171 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SecretSpec describes a kubernetes secret and where its data comes from
+// It's the value of each entry of SECRET_OBJECT, a plain list of vault paths is still accepted
+type SecretSpec struct {
+	// Vault paths merged into the secret, the later path wins on duplicate keys
+	Paths []PathSpec `json:"paths"`
+	// Vault folder listed recursively, it can't be combined with paths
+	Directory *DirectorySpec `json:"directory,omitempty"`
+	// Signed ssh certificate written as a kubernetes.io/ssh-auth secret, it can't be combined with paths
+	SSH *SSHSpec `json:"ssh,omitempty"`
+	// Credentials issued by the aws secrets engine, it can't be combined with paths
+	AWS *AWSSpec `json:"aws,omitempty"`
+	// Keys that are generated and written to vault when they are missing, only with BOOTSTRAP_SECRETS
+	Generate map[string]*GeneratorSpec `json:"generate,omitempty"`
+	// Keys of the secret holding transit ciphertext, with the name of the transit key that decrypts them
+	Transit map[string]string `json:"transit,omitempty"`
+	// Vault namespace of the paths that don't set their own, VAULT_NAMESPACE is used when it's empty
+	VaultNamespace string `json:"vaultNamespace,omitempty"`
+	// Type of the kubernetes secret, ex. kubernetes.io/tls, Opaque when it's empty; an ssh secret has its own
+	Type string `json:"type,omitempty"`
+	// Namespace the secret is written to instead of the default one
+	Namespace string `json:"namespace,omitempty"`
+	// Namespaces the secret is copied into
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Label selector of the namespaces the secret is copied into, ex. team=sre,env!=prod
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+}
+
+// UnmarshalJSON accepts both the legacy list of paths and the full spec
+func (s *SecretSpec) UnmarshalJSON(data []byte) error {
+	var paths []PathSpec
+	if err := json.Unmarshal(data, &paths); err == nil {
+		*s = SecretSpec{Paths: paths}
+		return nil
+	}
+	// Alias drops the methods so we don't end up here again
+	type alias SecretSpec
+	var spec alias
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("secret must be a list of vault paths or an object: %s", err)
+	}
+	*s = SecretSpec(spec)
+	return nil
+}
+
+// HasTargets tells if the spec chooses its own namespaces
+func (s *SecretSpec) HasTargets() bool {
+	return s.Namespace != "" || len(s.Namespaces) != 0 || s.NamespaceSelector != ""
+}
+
+// DirectorySpec is a kv v2 folder whose secrets are synced without listing them one by one
+type DirectorySpec struct {
+	// Folder relative to VAULT_SECRET_PATH, every secret under it and under its sub folders is synced
+	Path string `json:"path"`
+	// Vault namespace of the folder, it takes over the namespace of the secret
+	Namespace string `json:"namespace,omitempty"`
+	// Template of the kubernetes secret name of each vault secret, ex. {{ .Key }}-{{ .Base }}
+	Name string `json:"name,omitempty"`
+	// Glob patterns of the vault secrets to sync and to leave out, relative to the folder
+	// A pattern without a slash is matched against the last element of the path only
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Merge every vault secret of the folder into a single kubernetes secret instead
+	Merge bool `json:"merge,omitempty"`
+}
+
+// SSHSpec is a certificate signed by the ssh secrets engine
+type SSHSpec struct {
+	// Role the public key is signed with, ssh/sign/<role>
+	Role string `json:"role"`
+	// Mount of the ssh secrets engine, VAULT_SSH_PATH is used when it's empty
+	Mount string `json:"mount,omitempty"`
+	// Path relative to VAULT_SECRET_PATH of the key pair, with private_key and an optional public_key
+	// A new ed25519 key pair is generated every time the certificate is signed when it's empty
+	KeyPath string `json:"keyPath,omitempty"`
+	// Principals, ttl and type (user or host) of the certificate, the role decides when they are empty
+	Principals []string `json:"principals,omitempty"`
+	TTL        string   `json:"ttl,omitempty"`
+	CertType   string   `json:"certType,omitempty"`
+	// Sign again once less than this is left, ex. 10m, a third of the validity by default
+	RenewBefore string `json:"renewBefore,omitempty"`
+}
+
+// AWSSpec is a set of credentials issued by the aws secrets engine
+type AWSSpec struct {
+	// Role the credentials are issued for
+	Role string `json:"role"`
+	// Mount of the aws secrets engine, VAULT_AWS_PATH is used when it's empty
+	Mount string `json:"mount,omitempty"`
+	// Endpoint the credentials are read from, creds (default) or sts
+	Endpoint string `json:"endpoint,omitempty"`
+	// Arn of the role to assume when the vault role allows more than one, and the ttl of the credentials
+	RoleArn string `json:"roleArn,omitempty"`
+	TTL     string `json:"ttl,omitempty"`
+	// How the credentials are written, env (AWS_ACCESS_KEY_ID and co.) or file (a credentials file)
+	Format string `json:"format,omitempty"`
+	// Profile and key of the credentials file, default and credentials by default
+	Profile string `json:"profile,omitempty"`
+	FileKey string `json:"fileKey,omitempty"`
+	// Renew or issue again once less than this is left, ex. 10m, a third of the lease by default
+	RenewBefore string `json:"renewBefore,omitempty"`
+}
+
+// GeneratorSpec tells how the value of a missing key is generated
+type GeneratorSpec struct {
+	// Number of characters, 32 by default
+	Length int `json:"length,omitempty"`
+	// Characters the value is made of, one of alphanumeric (default), alpha, numeric, hex, symbols
+	// or the characters themselves, ex. abcdef0123456789
+	Charset string `json:"charset,omitempty"`
+	// Password policy of vault that generates the value instead, sys/policies/password/<policy>/generate
+	Policy string `json:"policy,omitempty"`
+	// Vault path the value is written to, the last path of the secret by default
+	Path string `json:"path,omitempty"`
+}
+
+// PathSpec is a single vault path of a secret
+// It's either a plain path, a namespace prefixed path such as "@shared:registry/creds" or an object
+type PathSpec struct {
+	// Path of the secret relative to VAULT_SECRET_PATH
+	Path string `json:"path"`
+	// Vault namespace of the path, it takes over the namespace of the secret
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// UnmarshalJSON accepts the plain or prefixed path as well as the object
+func (p *PathSpec) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*p = ParsePath(path)
+		return nil
+	}
+	type alias PathSpec
+	var spec alias
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("path must be a string or an object: %s", err)
+	}
+	*p = PathSpec(spec)
+	p.Path = strings.TrimSpace(p.Path)
+	return nil
+}
+
+// MarshalJSON writes the plain path when there's no namespace, the way it's usually written by hand
+func (p PathSpec) MarshalJSON() ([]byte, error) {
+	if p.Namespace == "" && ParsePath(p.Path) == p {
+		return json.Marshal(p.Path)
+	}
+	type alias PathSpec
+	return json.Marshal(alias(p))
+}
+
+// The namespace prefix of a path, "@" then a namespace such as "parent/child" and a colon
+var namespacePrefix = regexp.MustCompile(`^@([A-Za-z0-9_.-]+(?:/[A-Za-z0-9_.-]+)*):(.+)$`)
+
+// ParsePath splits the optional "@namespace:" prefix off a path
+// Any other path is kept as it is, a colon in a plain path never makes it read from another namespace
+func ParsePath(path string) PathSpec {
+	path = strings.TrimSpace(path)
+	if m := namespacePrefix.FindStringSubmatch(path); m != nil {
+		return PathSpec{Namespace: m[1], Path: strings.TrimSpace(m[2])}
+	}
+	return PathSpec{Path: path}
+}
@@ -6,11 +6,16 @@ import (
 	"strings"
 )
 
+// Type of the secrets holding the token of a service account, kubernetes fills them in on its own
+const ServiceAccountTokenType = "kubernetes.io/service-account-token"
+
 // Kubernetes object metadata struct
 type Meta struct {
 	Name      string                 `json:"name"`
 	Namespace string                 `json:"namespace"`
-	Labels    map[string]interface{} `json:"labels"`
+	Labels    map[string]interface{} `json:"labels,omitempty"`
+	// Annotations are only set by us when there's something to remember, ex. the vault versions
+	Annotations map[string]interface{} `json:"annotations,omitempty"`
 }
 
 // Kubernetes secret object root struct
@@ -24,14 +29,15 @@ type Secret struct {
 
 // Construct the kubernetes manifest and return it as a byte
 // the manifest will be in json format
-func object(name, ns string, data map[string]interface{}) ([]byte, error) {
+// an empty secretType is an Opaque secret
+func object(name, ns, secretType string, data, annotations map[string]interface{}) ([]byte, error) {
 	// get the appName and inject it to metadata.labels
 	var appName string
 
 	// strings.Split returns a slice of byte [byte1 byte2 byte3]
 	str := strings.Split(name, "-")
-	// verify if the slice is not empty otherwise return an error
-	if len(str) == 0 {
+	// verify the name has at least two parts otherwise return an error, ex. app-secret
+	if len(str) < 2 {
 		return nil, fmt.Errorf("name of secrets does not satisfy the naming requirement")
 	}
 	// get the second byte from right
@@ -66,11 +72,15 @@ func object(name, ns string, data map[string]interface{}) ([]byte, error) {
 	// get the component or the environment
 	component = strings.Join(namespace[1:], "-")
 
+	if secretType == "" {
+		secretType = "Opaque"
+	}
+
 	// construct the manifest and return the values
 	manifest := &Secret{
 		ApiVersion: "v1",
 		Kind:       "Secret",
-		Type:       "Opaque",
+		Type:       secretType,
 		Data:       data,
 		Metadata: &Meta{
 			Name:      name,
@@ -80,10 +90,10 @@ func object(name, ns string, data map[string]interface{}) ([]byte, error) {
 				"app.kubernetes.io/component":  component,
 				"app.kubernetes.io/managed-by": "vault-gopher",
 			},
+			Annotations: annotations,
 		},
 	}
 
 	ret, _ := json.Marshal(manifest)
 	return ret, nil
 }
-
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// The secret has been created or updated in kubernetes
+	StatusSynced = "synced"
+	// The secret in kubernetes already has the same content as vault
+	StatusUnchanged = "unchanged"
+	// The secret could not be synced, see the error of the secret
+	StatusFailed = "failed"
+	// The secret was not processed because another secret failed first
+	StatusSkipped = "skipped"
+	// The secret in kubernetes differs from vault, only in dry-run where nothing is written
+	StatusDrifted = "drifted"
+)
+
+// Report is the outcome of a sync run
+type Report struct {
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	Synced     int            `json:"synced"`
+	Unchanged  int            `json:"unchanged"`
+	Failed     int            `json:"failed"`
+	Skipped    int            `json:"skipped"`
+	Drifted    int            `json:"drifted,omitempty"`
+	Secrets    []SecretReport `json:"secrets"`
+}
+
+// SecretReport is the outcome of a single kubernetes secret
+type SecretReport struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// What would be written in dry-run
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Change is a difference between the secret in kubernetes and the one rendered from vault
+// The values of the data are never given, only a hash of them
+type Change struct {
+	// One of data, type, label or annotation
+	Field string `json:"field"`
+	Key   string `json:"key,omitempty"`
+	// One of added, removed or changed
+	Action string `json:"action"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// Add the outcome of a secret to the report and keep the counters up to date
+func (r *Report) add(s SecretReport) {
+	switch s.Status {
+	case StatusSynced:
+		r.Synced++
+	case StatusUnchanged:
+		r.Unchanged++
+	case StatusFailed:
+		r.Failed++
+	case StatusSkipped:
+		r.Skipped++
+	case StatusDrifted:
+		r.Drifted++
+	}
+	r.Secrets = append(r.Secrets, s)
+}
+
+// Write the report as json to the destination
+// "-" writes to stdout, anything else is used as a file path and an empty destination writes nothing
+func writeReport(r *Report, dest string) error {
+	if dest == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode sync report: %s", err)
+	}
+	data = append(data, '\n')
+	if dest == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(dest, data, 0644); err != nil {
+		return fmt.Errorf("cannot write sync report to %s: %s", dest, err)
+	}
+	return nil
+}
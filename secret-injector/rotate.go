@@ -0,0 +1,308 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+const (
+	// PreviousAnnotation holds the keys a rotation kept the previous value of and until when
+	PreviousAnnotation = "vault-gopher.io/previous"
+
+	// Suffix of the key that holds the value from before the rotation
+	PreviousSuffix = "_previous"
+)
+
+// Plural of the kinds of workloads that can be restarted, as they are written on the command line
+var workloadResources = map[string]string{
+	"deployment":  "deployments",
+	"deploy":      "deployments",
+	"statefulset": "statefulsets",
+	"sts":         "statefulsets",
+	"daemonset":   "daemonsets",
+	"ds":          "daemonsets",
+}
+
+// RotateOptions tells what is rotated and what happens around it
+type RotateOptions struct {
+	// Name of the secret of SECRET_OBJECT
+	Secret string
+	// Keys that get a new value, every key with a generator by default
+	Keys []string
+	// How long the value from before the rotation is kept under <key>_previous, 0 drops it right away
+	Grace time.Duration
+	// Workloads restarted once the secret is synced, as kind/name ex. deployment/billing
+	Restart []string
+}
+
+// rotation is the json of PreviousAnnotation
+type rotation struct {
+	Keys  []string  `json:"keys"`
+	Until time.Time `json:"until"`
+}
+
+// Rotate generates new values for the keys of a secret, writes them as a new kv v2 version and syncs the secret
+func Rotate(ctx context.Context, objectName string, opts RotateOptions) error {
+	syncOpts, err := EnvOptions()
+	if err != nil {
+		return err
+	}
+	syncOpts.ObjectName = objectName
+	s := NewSyncer(syncOpts)
+
+	ctx, cancel := runContext(ctx, syncOpts.Timeout)
+	defer cancel()
+	method, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := method.Login(ctx)
+	if err != nil {
+		return err
+	}
+	// Only a secret read from kv paths is rotated, its token holds no lease
+	defer s.revokeToken(s.authMethodName(), token.ClientToken, nil)
+	return s.rotateSecret(ctx, syncOpts.Secrets, opts, token.ClientToken)
+}
+
+// The previous values are put aside in kubernetes before vault is written, so a failure in between doesn't lose them
+func (s *Syncer) rotateSecret(ctx context.Context, vars map[string]*models.SecretSpec, opts RotateOptions, clientToken string) error {
+	objectName := s.opts.ObjectName
+	spec, ok := vars[opts.Secret]
+	if !ok || spec == nil {
+		return fmt.Errorf("secret %s is not in SECRET_OBJECT", opts.Secret)
+	}
+	if spec.SSH != nil || spec.AWS != nil || spec.Directory != nil {
+		return fmt.Errorf("secret %s is not read from kv paths, it can't be rotated", opts.Secret)
+	}
+	keys := opts.Keys
+	if len(keys) == 0 {
+		for key := range spec.Generate {
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("secret %s has no generator, give the keys to rotate", opts.Secret)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.HasSuffix(key, PreviousSuffix) {
+			return fmt.Errorf("key %s holds a previous value, it can't be rotated", key)
+		}
+	}
+	restarts := make([][2]string, 0, len(opts.Restart))
+	for _, workload := range opts.Restart {
+		parts := strings.SplitN(workload, "/", 2)
+		resource, ok := workloadResources[strings.ToLower(parts[0])]
+		if !ok || len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("workload %s should be deployment/<name>, statefulset/<name> or daemonset/<name>", workload)
+		}
+		restarts = append(restarts, [2]string{resource, parts[1]})
+	}
+	writes, err := bootstrapWrites(spec, keys)
+	if err != nil {
+		return fmt.Errorf("cannot rotate secret %s: %s", opts.Secret, err)
+	}
+
+	cluster, err := s.kubernetesCluster()
+	if err != nil {
+		return err
+	}
+	resolver := &namespaceResolver{
+		client:    s.kubernetesClient(),
+		cluster:   cluster,
+		allowed:   s.opts.AllowedNamespaces,
+		selectors: make(map[string][]string),
+	}
+	targets, err := resolver.targets(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	if opts.Grace > 0 {
+		until := time.Now().Add(opts.Grace).UTC().Truncate(time.Second)
+		for _, t := range targets {
+			if t.err != nil {
+				continue
+			}
+			if err := s.keepPrevious(ctx, cluster, t.namespace, objectName, opts.Secret, keys, until); err != nil {
+				return err
+			}
+		}
+	}
+
+	client, err := s.vaultClient()
+	if err != nil {
+		return err
+	}
+	dataUrl := &RequestUrl{
+		BaseUrl: s.opts.VaultAddress,
+		Path:    s.opts.SecretPath,
+	}
+	for _, write := range writes {
+		url := dataUrl.GetPath(write.path.Path)
+		namespace := s.pathNamespace(spec, write.path)
+		data, version, err := client.ReadKV(ctx, clientToken, url, namespace)
+		if err != nil {
+			return fmt.Errorf("cannot read %s to rotate it: %s", write.path.Path, err)
+		}
+		if data == nil {
+			data = make(map[string]interface{})
+		}
+		for _, key := range write.keys {
+			value, err := s.generateValue(ctx, client, clientToken, namespace, spec.Generate[key])
+			if err != nil {
+				return fmt.Errorf("cannot generate %s of %s: %s", key, opts.Secret, err)
+			}
+			data[key] = value
+		}
+		if err := client.WriteKV(ctx, clientToken, url, namespace, data, version); err != nil {
+			return fmt.Errorf("cannot write %s to %s: %s", strings.Join(write.keys, ", "), write.path.Path, err)
+		}
+		logger.Infof("rotated %s of secret %s in %s", strings.Join(write.keys, ", "), opts.Secret, write.path.Path)
+	}
+
+	if _, err := s.syncSecrets(ctx, map[string]*models.SecretSpec{opts.Secret: spec}, clientToken, nil, s.opts.TrackVersions); err != nil {
+		return err
+	}
+
+	// The workloads only pick the new values up once their pods are started again
+	k8s := s.kubernetesClient()
+	restartedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, t := range targets {
+		if t.err != nil {
+			continue
+		}
+		for _, workload := range restarts {
+			status, err := k8s.Restart(ctx, cluster, t.namespace, workload[0], workload[1], restartedAt)
+			if err != nil {
+				return err
+			}
+			if status == http.StatusNotFound {
+				logger.Warnf("%s/%s not found in %s, nothing to restart", workload[0], workload[1], t.namespace)
+				continue
+			}
+			logger.Infof("restarted %s/%s in %s", workload[0], workload[1], t.namespace)
+		}
+	}
+	return nil
+}
+
+// Copy the current values of the keys of a live secret to <key>_previous until the end of the grace period
+// A secret that doesn't exist yet has nothing to keep
+func (s *Syncer) keepPrevious(ctx context.Context, cluster *models.Cluster, ns, objectName, name string, keys []string, until time.Time) error {
+	client := s.kubernetesClient()
+	status, live, err := client.GetSecret(ctx, cluster, ns, objectName, name)
+	if err != nil {
+		return fmt.Errorf("cannot read secret %s in %s: %s", name, ns, err)
+	}
+	if status != http.StatusOK {
+		return nil
+	}
+
+	data, _ := live["data"].(map[string]interface{})
+	r := rotation{Until: until}
+	// Keys of a rotation that is still in its grace period are kept as well
+	if current := liveRotation(live); current != nil && time.Now().Before(current.Until) {
+		r.Keys = append(r.Keys, current.Keys...)
+	}
+	for _, key := range keys {
+		// The values are copied as they are, base64 encoded
+		if value, ok := data[key]; ok {
+			data[key+PreviousSuffix] = value
+			r.Keys = append(r.Keys, key)
+		}
+	}
+	if len(r.Keys) == 0 {
+		return nil
+	}
+	r.Keys = uniqueStrings(r.Keys)
+	value, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	meta, _ := live["metadata"].(map[string]interface{})
+	annotations, _ := meta["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = make(map[string]interface{})
+		meta["annotations"] = annotations
+	}
+	annotations[PreviousAnnotation] = string(value)
+
+	// The live object is sent back with its resourceVersion, a change made in between makes the write fail
+	payload, err := json.Marshal(live)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Create(ctx, cluster, ns, objectName, name, status, payload)
+	if err != nil {
+		return fmt.Errorf("cannot keep the previous values of secret %s in %s: %s", name, ns, err)
+	}
+	if code, ok := resp["code"]; ok {
+		return fmt.Errorf("cannot keep the previous values of secret %s in %s, kubernetes api responded with: %v", name, ns, code)
+	}
+	return nil
+}
+
+// Return the rotation of a live secret, nil when it has none
+func liveRotation(live map[string]interface{}) *rotation {
+	meta, _ := live["metadata"].(map[string]interface{})
+	annotations, _ := meta["annotations"].(map[string]interface{})
+	value, _ := annotations[PreviousAnnotation].(string)
+	if value == "" {
+		return nil
+	}
+	var r rotation
+	if err := json.Unmarshal([]byte(value), &r); err != nil {
+		return nil
+	}
+	return &r
+}
+
+// Add the previous values of a live secret to the data that is written while its grace period lasts
+// Once it's over they are left out, with the annotation, and the write drops them
+func withPrevious(live, data, annotations map[string]interface{}, now time.Time) (map[string]interface{}, map[string]interface{}) {
+	r := liveRotation(live)
+	if r == nil || !now.Before(r.Until) {
+		return data, annotations
+	}
+	liveData, _ := live["data"].(map[string]interface{})
+	meta, _ := live["metadata"].(map[string]interface{})
+	liveAnnotations, _ := meta["annotations"].(map[string]interface{})
+
+	// The maps are shared by every copy of the secret, they are not changed in place
+	retData := make(map[string]interface{}, len(data)+len(r.Keys))
+	for key, value := range data {
+		retData[key] = value
+	}
+	for _, key := range r.Keys {
+		if value, ok := liveData[key+PreviousSuffix]; ok {
+			retData[key+PreviousSuffix] = value
+		}
+	}
+	retAnnotations := make(map[string]interface{}, len(annotations)+1)
+	for key, value := range annotations {
+		retAnnotations[key] = value
+	}
+	retAnnotations[PreviousAnnotation] = liveAnnotations[PreviousAnnotation]
+	return retData, retAnnotations
+}
+
+// Return the strings sorted without duplicates
+func uniqueStrings(s []string) []string {
+	sort.Strings(s)
+	ret := s[:0]
+	for i, item := range s {
+		if i == 0 || item != s[i-1] {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
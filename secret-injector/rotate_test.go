@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func Test_rotateSecret(t *testing.T) {
+	kv := &fakeKV{
+		data:     map[string]map[string]interface{}{"app/db": {"username": "app", "password": "old-password"}},
+		versions: map[string]int{"app/db": 1},
+	}
+	vault := httptest.NewServer(kv)
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+
+	s := NewSyncer(kube.options(vault.URL))
+
+	spec := &models.SecretSpec{
+		Paths:    []models.PathSpec{{Path: "app/db"}},
+		Generate: map[string]*models.GeneratorSpec{"password": {Length: 20}},
+	}
+	vars := map[string]*models.SecretSpec{"app-sit-secret": spec}
+	if _, err := s.syncSecrets(context.Background(), vars, "token", nil, false); err != nil {
+		t.Fatalf("syncSecrets() error = %v", err)
+	}
+
+	opts := RotateOptions{Secret: "app-sit-secret", Grace: time.Hour, Restart: []string{"deployment/app"}}
+	if err := s.rotateSecret(context.Background(), vars, opts, "token"); err != nil {
+		t.Fatalf("rotateSecret() error = %v", err)
+	}
+
+	password, _ := kv.data["app/db"]["password"].(string)
+	if len(password) != 20 || kv.versions["app/db"] != 2 || kv.data["app/db"]["username"] != "app" {
+		t.Errorf("rotateSecret() vault = %v at version %d, want a new password at version 2", kv.data["app/db"], kv.versions["app/db"])
+	}
+	want := map[string]string{"username": "app", "password": password, "password_previous": "old-password"}
+	if got := secretData(t, kube.object("sit-sre", "app-sit-secret")); !reflect.DeepEqual(got, want) {
+		t.Errorf("rotateSecret() secret = %v, want %v", got, want)
+	}
+	if want := []string{"/apis/apps/v1/namespaces/sit-sre/deployments/app"}; !reflect.DeepEqual(kube.restarted, want) {
+		t.Errorf("rotateSecret() restarted = %v, want %v", kube.restarted, want)
+	}
+
+	// The previous value stays while the grace period lasts
+	if _, err := s.syncSecrets(context.Background(), vars, "token", nil, false); err != nil {
+		t.Fatalf("syncSecrets() error = %v", err)
+	}
+	if got := secretData(t, kube.object("sit-sre", "app-sit-secret")); !reflect.DeepEqual(got, want) {
+		t.Errorf("syncSecrets() during the grace period secret = %v, want %v", got, want)
+	}
+
+	// And is dropped by the first sync after it
+	annotations := kube.object("sit-sre", "app-sit-secret")["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	expired, _ := json.Marshal(rotation{Keys: []string{"password"}, Until: time.Now().Add(-time.Minute)})
+	annotations[PreviousAnnotation] = string(expired)
+	if _, err := s.syncSecrets(context.Background(), vars, "token", nil, false); err != nil {
+		t.Fatalf("syncSecrets() error = %v", err)
+	}
+	delete(want, "password_previous")
+	if got := secretData(t, kube.object("sit-sre", "app-sit-secret")); !reflect.DeepEqual(got, want) {
+		t.Errorf("syncSecrets() after the grace period secret = %v, want %v", got, want)
+	}
+}
+
+func Test_rotateSecret_invalid(t *testing.T) {
+	vars := map[string]*models.SecretSpec{
+		"app-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}}},
+		"git-sit-secret": {SSH: &models.SSHSpec{Role: "git"}},
+	}
+	tests := []struct {
+		name string
+		opts RotateOptions
+	}{
+		{name: "unknown-secret", opts: RotateOptions{Secret: "other-sit-secret", Keys: []string{"password"}}},
+		{name: "no-generator", opts: RotateOptions{Secret: "app-sit-secret"}},
+		{name: "ssh", opts: RotateOptions{Secret: "git-sit-secret", Keys: []string{"password"}}},
+		{name: "previous-key", opts: RotateOptions{Secret: "app-sit-secret", Keys: []string{"password_previous"}}},
+		{name: "workload", opts: RotateOptions{Secret: "app-sit-secret", Keys: []string{"password"}, Restart: []string{"job/app"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewSyncer(DefaultOptions()).rotateSecret(context.Background(), vars, tt.opts, "token"); err == nil {
+				t.Errorf("rotateSecret() error = nil, want an error")
+			}
+		})
+	}
+}
+
+// Return the decoded data of a secret written to the fake api
+func secretData(t *testing.T, object map[string]interface{}) map[string]string {
+	data, _ := object["data"].(map[string]interface{})
+	ret := make(map[string]string, len(data))
+	for key, value := range data {
+		decoded, err := base64.StdEncoding.DecodeString(value.(string))
+		if err != nil {
+			t.Fatalf("%s is not base64 encoded: %v", key, err)
+		}
+		ret[key] = string(decoded)
+	}
+	return ret
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Type of the kubernetes secret holding the ssh credentials
+	SSHAuthSecretType = "kubernetes.io/ssh-auth"
+
+	// Keys of the ssh secret, ssh picks up <key>-cert.pub on its own when the secret is mounted as files
+	SSHPrivateKey  = "ssh-privatekey"
+	SSHPublicKey   = "ssh-privatekey.pub"
+	SSHCertificate = "ssh-privatekey-cert.pub"
+)
+
+// Return the job that signs the certificate of an ssh secret
+// Nothing is signed when every copy of the secret has a certificate that is still valid long enough
+func (s *Syncer) sshJob(ctx context.Context, secret *secretJob, cluster *models.Cluster, clientToken, objectName string) func() error {
+	spec := secret.spec.SSH
+	return func() error {
+		if len(secret.spec.Paths) != 0 || secret.spec.Directory != nil {
+			return failure(ReasonVaultReadFailed, fmt.Errorf("ssh secret %s can't have paths as well", secret.name))
+		}
+		if spec.Role == "" {
+			return failure(ReasonVaultReadFailed, fmt.Errorf("ssh secret %s has no role", secret.name))
+		}
+		var renewBefore time.Duration
+		if spec.RenewBefore != "" {
+			d, err := time.ParseDuration(spec.RenewBefore)
+			if err != nil {
+				return failure(ReasonVaultReadFailed, fmt.Errorf("invalid renewBefore of ssh secret %s: %s", secret.name, err))
+			}
+			renewBefore = d
+		}
+
+		secret.secretType = SSHAuthSecretType
+		if s.certificatesValid(ctx, secret, cluster, objectName, renewBefore, time.Now()) {
+			secret.upToDate = true
+			return nil
+		}
+
+		client, err := s.vaultClient()
+		if err != nil {
+			return failure(ReasonVaultReadFailed, err)
+		}
+		namespace := s.pathNamespace(secret.spec, models.PathSpec{})
+		privateKey, publicKey, err := s.sshKeyPair(ctx, client, spec, clientToken, namespace)
+		if err != nil {
+			return failure(ReasonVaultReadFailed, fmt.Errorf("cannot get the key pair of ssh secret %s: %s", secret.name, err))
+		}
+
+		request := map[string]interface{}{"public_key": publicKey}
+		if len(spec.Principals) != 0 {
+			request["valid_principals"] = strings.Join(spec.Principals, ",")
+		}
+		if spec.TTL != "" {
+			request["ttl"] = spec.TTL
+		}
+		if spec.CertType != "" {
+			request["cert_type"] = spec.CertType
+		}
+		mount := spec.Mount
+		if mount == "" {
+			mount = s.opts.SSHPath
+		}
+		signUrl := &RequestUrl{BaseUrl: s.opts.VaultAddress, Path: mount}
+		certificate, err := client.SignSSH(ctx, clientToken, signUrl.GetPath("sign/"+spec.Role), namespace, request)
+		if err != nil {
+			return failure(ReasonVaultReadFailed, fmt.Errorf("cannot sign the key of ssh secret %s: %s", secret.name, err))
+		}
+
+		secret.payloads = []map[string]interface{}{{
+			SSHPrivateKey:  privateKey,
+			SSHPublicKey:   publicKey,
+			SSHCertificate: certificate,
+		}}
+		return nil
+	}
+}
+
+// Tell if every copy of the secret has a certificate that is valid for longer than renewBefore
+// A third of the validity of the certificate is used when renewBefore is 0
+func (s *Syncer) certificatesValid(ctx context.Context, secret *secretJob, cluster *models.Cluster, objectName string, renewBefore time.Duration, now time.Time) bool {
+	client := s.kubernetesClient()
+	valid := false
+	for _, t := range secret.targets {
+		if t.err != nil {
+			continue
+		}
+		status, live, err := client.GetSecret(ctx, cluster, t.namespace, objectName, secret.name)
+		if err != nil || status != 200 {
+			return false
+		}
+		data, _ := live["data"].(map[string]interface{})
+		encoded, _ := data[SSHCertificate].(string)
+		certificate, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return false
+		}
+		validAfter, validBefore, err := certificateValidity(certificate)
+		if err != nil {
+			return false
+		}
+		remaining := renewBefore
+		if remaining == 0 {
+			remaining = validBefore.Sub(validAfter) / 3
+		}
+		if now.Add(remaining).After(validBefore) {
+			return false
+		}
+		valid = true
+	}
+	return valid
+}
+
+// Return the validity of a certificate in authorized_keys format
+func certificateValidity(data []byte) (time.Time, time.Time, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	certificate, ok := key.(*ssh.Certificate)
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("key is not a certificate")
+	}
+	validBefore := time.Unix(int64(certificate.ValidBefore), 0)
+	if certificate.ValidBefore == ssh.CertTimeInfinity {
+		validBefore = time.Unix(1<<62, 0)
+	}
+	return time.Unix(int64(certificate.ValidAfter), 0), validBefore, nil
+}
+
+// Return the private key and the public key in authorized_keys format to sign
+// The key pair is read from vault when the spec has a key path, a new one is generated otherwise
+func (s *Syncer) sshKeyPair(ctx context.Context, client Vault, spec *models.SSHSpec, clientToken, namespace string) (string, string, error) {
+	if spec.KeyPath == "" {
+		return generateKeyPair()
+	}
+
+	dataUrl := &RequestUrl{BaseUrl: s.opts.VaultAddress, Path: s.opts.SecretPath}
+	path := models.ParsePath(spec.KeyPath)
+	if path.Namespace != "" {
+		namespace = path.Namespace
+	}
+	payload, err := client.GetData(ctx, clientToken, dataUrl.GetPath(path.Path), namespace)
+	if err != nil {
+		return "", "", err
+	}
+	privateKey, _ := payload["private_key"].(string)
+	if privateKey == "" {
+		return "", "", fmt.Errorf("no private_key found in %s", spec.KeyPath)
+	}
+	if publicKey, _ := payload["public_key"].(string); publicKey != "" {
+		return privateKey, strings.TrimSpace(publicKey), nil
+	}
+	// The public key is derived from the private key when it's not kept next to it
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", "", fmt.Errorf("cannot parse private_key of %s: %s", spec.KeyPath, err)
+	}
+	return privateKey, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// Generate an ed25519 key pair, the private key in the openssh format and the public key in authorized_keys format
+func generateKeyPair() (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", err
+	}
+	block, err := ssh.MarshalPrivateKey(private, "vault-gopher")
+	if err != nil {
+		return "", "", err
+	}
+	return string(pem.EncodeToMemory(block)), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))), nil
+}
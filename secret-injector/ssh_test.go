@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_generateKeyPair(t *testing.T) {
+	privateKey, publicKey, err := generateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		t.Fatalf("ParsePrivateKey() error = %v", err)
+	}
+	got := string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	if got != publicKey+"\n" {
+		t.Errorf("generateKeyPair() public key = %v, want %v", publicKey, got)
+	}
+}
+
+func Test_syncSecrets_ssh(t *testing.T) {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedKey, storedPublic, err := generateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var signs []map[string]interface{}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/v1/secret/data/ssh/deploy" {
+			fmt.Fprintf(w, `{"data": {"data": {"private_key": %q}}}`, storedKey)
+			return
+		}
+		if r.URL.Path != "/v1/ssh-client/sign/git" {
+			t.Errorf("url is incorrect: %s", r.URL.Path)
+		}
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		signs = append(signs, request)
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(request["public_key"].(string)))
+		if err != nil {
+			t.Errorf("public key is incorrect: %s", err)
+			return
+		}
+		certificate := &ssh.Certificate{
+			Key:             key,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"git"},
+			ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		if err := certificate.SignCert(rand.Reader, ca); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintf(w, `{"data": {"serial_number": "1", "signed_key": %q}}`, ssh.MarshalAuthorizedKey(certificate))
+	}))
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+
+	s := NewSyncer(kube.options(vault.URL))
+
+	tests := []struct {
+		name       string
+		spec       *models.SSHSpec
+		wantSigns  int
+		wantWrites int
+		wantKey    string
+	}{
+		{
+			name:       "generated-key",
+			spec:       &models.SSHSpec{Role: "git", Mount: "ssh-client", Principals: []string{"git"}, TTL: "1h"},
+			wantSigns:  1,
+			wantWrites: 1,
+		},
+		{
+			name:       "still-valid",
+			spec:       &models.SSHSpec{Role: "git", Mount: "ssh-client", Principals: []string{"git"}, TTL: "1h"},
+			wantSigns:  0,
+			wantWrites: 0,
+		},
+		{
+			name:       "renew-before",
+			spec:       &models.SSHSpec{Role: "git", Mount: "ssh-client", KeyPath: "ssh/deploy", RenewBefore: "2h"},
+			wantSigns:  1,
+			wantWrites: 1,
+			wantKey:    storedKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			signs = nil
+			mu.Unlock()
+			kube.reset()
+
+			vars := map[string]*models.SecretSpec{"git-sit-secret": {SSH: tt.spec}}
+			if _, err := s.syncSecrets(context.Background(), vars, "token", nil, false); err != nil {
+				t.Fatalf("syncSecrets() error = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(signs) != tt.wantSigns {
+				t.Errorf("syncSecrets() signs = %v, want %v", len(signs), tt.wantSigns)
+			}
+			if writes := kube.writes(); writes != tt.wantWrites {
+				t.Errorf("syncSecrets() writes = %v, want %v", writes, tt.wantWrites)
+			}
+			if tt.wantSigns != 0 {
+				want := map[string]interface{}{"valid_principals": "git", "ttl": "1h"}
+				if tt.spec.KeyPath != "" {
+					want = map[string]interface{}{"public_key": storedPublic}
+				} else {
+					want["public_key"] = signs[0]["public_key"]
+				}
+				if !reflect.DeepEqual(signs[0], want) {
+					t.Errorf("syncSecrets() sign request = %v, want %v", signs[0], want)
+				}
+			}
+
+			object := kube.object("sit-sre", "git-sit-secret")
+			if object["type"] != SSHAuthSecretType {
+				t.Errorf("syncSecrets() type = %v, want %v", object["type"], SSHAuthSecretType)
+			}
+			data := object["data"].(map[string]interface{})
+			for _, key := range []string{SSHPrivateKey, SSHPublicKey, SSHCertificate} {
+				if data[key] == nil {
+					t.Errorf("syncSecrets() key %s is missing", key)
+				}
+			}
+			if tt.wantKey != "" {
+				got, _ := base64.StdEncoding.DecodeString(data[SSHPrivateKey].(string))
+				if string(got) != tt.wantKey {
+					t.Errorf("syncSecrets() private key is not the one kept in vault")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,711 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"github.com/trx35479/vault-gopher/secret-injector/utils"
+	"github.com/trx35479/vault-gopher/secret-injector/worker"
+)
+
+// secretJob holds the state of a single kubernetes secret while it is being synced
+type secretJob struct {
+	// Name of the kubernetes secret
+	name string
+	// Where the data of the secret comes from and where it goes
+	spec *models.SecretSpec
+	// Payload fetched from each of the paths, same order as spec.Paths
+	payloads []map[string]interface{}
+	// Cache key and kv v2 version of each of the paths when the versions are tracked
+	keys     []string
+	versions []*models.Version
+	// Versions annotation written with the secret, empty when a version is unknown
+	annotation string
+	// Every copy of the secret was written from the current versions, nothing is read or written
+	upToDate bool
+	// Payloads merged together and decrypted, what is written to kubernetes
+	data map[string]interface{}
+	// Type of the kubernetes secret, Opaque when it's empty
+	secretType string
+	// Annotations written with the secret besides the versions, ex. the lease of dynamic credentials
+	annotations map[string]interface{}
+	// Copies of the secret, one per namespace
+	targets []*target
+	// First error encountered while resolving or fetching the secret, it fails every target
+	err error
+}
+
+// target is the copy of a secret in a single namespace
+type target struct {
+	namespace string
+	// Outcome of the copy, one of the Status constants
+	status string
+	// Error encountered while writing the copy
+	err error
+	// What would be written, in dry-run
+	changes []Change
+}
+
+// Fetch every vault path and write every secret using a pool of workers
+// Errors are collected per secret so a failing path doesn't hide the others
+// The cache is only given by the long-running mode, it's nil for a single sync
+// With track the versions of the paths are compared with the ones applied to kubernetes, see VAULT_TRACK_VERSIONS
+// The report is returned with the error of the secrets that failed, it's nil when nothing was synced
+func (s *Syncer) syncSecrets(ctx context.Context, vars map[string]*models.SecretSpec, clientToken string, cache *versionCache, track bool) (*Report, error) {
+	report := &Report{StartedAt: time.Now().UTC()}
+	objectName := s.opts.ObjectName
+
+	// The cluster is resolved once and shared by the writes and the events
+	cluster, err := s.kubernetesCluster()
+	if err != nil {
+		return nil, err
+	}
+
+	// We use the temporary token that vault server provided to access the secret
+	// Client token has ttl equals to 900second
+	dataUrl := &RequestUrl{
+		BaseUrl: s.opts.VaultAddress,
+		Path:    s.opts.SecretPath,
+	}
+
+	limiter := worker.NewLimiter(s.opts.RateLimit)
+
+	// The directory entries are turned into plain secrets before anything else
+	vars, expandErrs := s.expandDirectories(ctx, vars, clientToken, limiter)
+
+	// Sort the names so the order of the work and the logs are predictable
+	names := make([]string, 0, len(vars)+len(expandErrs))
+	for key := range vars {
+		names = append(names, key)
+	}
+	for key := range expandErrs {
+		if _, ok := vars[key]; !ok {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+
+	resolver := &namespaceResolver{
+		client:    s.kubernetesClient(),
+		cluster:   cluster,
+		allowed:   s.opts.AllowedNamespaces,
+		selectors: make(map[string][]string),
+	}
+	secrets := make([]*secretJob, 0, len(names))
+	for _, name := range names {
+		spec := vars[name]
+		if spec == nil {
+			spec = &models.SecretSpec{}
+		}
+		secret := &secretJob{
+			name:       strings.TrimSpace(name),
+			spec:       spec,
+			secretType: spec.Type,
+			payloads:   make([]map[string]interface{}, len(spec.Paths)),
+			keys:       make([]string, len(spec.Paths)),
+			versions:   make([]*models.Version, len(spec.Paths)),
+		}
+		for i, value := range spec.Paths {
+			secret.keys[i] = versionKey(s.pathNamespace(spec, value), value.Path)
+		}
+		if err, ok := expandErrs[name]; ok {
+			secret.err = err
+			secrets = append(secrets, secret)
+			continue
+		}
+		secret.targets, secret.err = resolver.targets(ctx, spec)
+		secrets = append(secrets, secret)
+	}
+
+	// Every read goes through so the errors of all the secrets are collected, not only the first one
+	pool := &worker.Pool{
+		Concurrency: s.opts.Concurrency,
+		Context:     ctx,
+	}
+
+	// With VAULT_TRACK_VERSIONS the metadata is read first and only what has changed is fetched
+	if track {
+		s.trackVersions(ctx, secrets, cluster, clientToken, objectName, pool, limiter)
+	}
+
+	// First we fetch every path of every secret at the same time
+	var fetches []worker.Job
+	var owners []*secretJob
+	for _, secret := range secrets {
+		if secret.err != nil || secret.upToDate {
+			continue
+		}
+		// Credentials issued in dry-run would be new ones, they are never the same as what is in kubernetes
+		if s.opts.DryRun && (secret.spec.SSH != nil || secret.spec.AWS != nil) {
+			logger.Infof("secret %s holds credentials issued at sync time, it's not compared in dry-run", secret.name)
+			for _, t := range secret.targets {
+				if t.err == nil {
+					t.status = StatusSkipped
+				}
+			}
+			secret.upToDate = true
+			continue
+		}
+		// The certificate of an ssh secret is signed instead of read
+		if secret.spec.SSH != nil {
+			fetches = append(fetches, s.sshJob(ctx, secret, cluster, clientToken, objectName))
+			owners = append(owners, secret)
+			continue
+		}
+		// The credentials of an aws secret are issued or their lease is renewed
+		if secret.spec.AWS != nil {
+			fetches = append(fetches, s.awsJob(ctx, secret, cluster, clientToken, objectName))
+			owners = append(owners, secret)
+			continue
+		}
+		for i, value := range secret.spec.Paths {
+			secret, i := secret, i
+			// The long-running mode already has the payload when the path didn't change
+			if payload, ok := cache.payload(secret.keys[i], secret.versions[i]); ok {
+				secret.payloads[i] = payload
+				continue
+			}
+			secretPath := dataUrl.GetPath(value.Path)
+			namespace := s.pathNamespace(secret.spec, value)
+			fetches = append(fetches, func() error {
+				client, err := s.vaultClient()
+				if err != nil {
+					return failure(ReasonVaultReadFailed, err)
+				}
+				limiter.Wait()
+				payload, err := client.GetData(ctx, clientToken, secretPath, namespace)
+				if err != nil {
+					// A path of a new service may not exist yet, bootstrap creates it with the generated keys
+					if s.opts.Bootstrap && len(secret.spec.Generate) != 0 {
+						if data, _, kvErr := client.ReadKV(ctx, clientToken, secretPath, namespace); kvErr == nil && data == nil {
+							secret.payloads[i] = make(map[string]interface{})
+							return nil
+						}
+					}
+					return failure(ReasonVaultReadFailed,
+						fmt.Errorf("encountered error while fetching secrets from vault: %s", err))
+				}
+				secret.payloads[i] = payload
+				cache.setPayload(secret.keys[i], secret.versions[i], payload)
+				return nil
+			})
+			owners = append(owners, secret)
+		}
+	}
+	for i, err := range pool.Run(fetches) {
+		if err != nil && err != worker.ErrSkipped && owners[i].err == nil {
+			owners[i].err = err
+		}
+	}
+
+	for _, secret := range secrets {
+		if secret.err != nil || secret.upToDate {
+			continue
+		}
+		// Instantiate a map[string]interface{} type
+		// Placeholder of the kv secret we fetch from the vault
+		secret.data = make(map[string]interface{})
+		for _, payload := range secret.payloads {
+			// We safeguard the runtime here
+			// Sometimes a call to secret returns an empty object
+			for key, value := range payload {
+				secret.data[key] = value
+			}
+		}
+	}
+
+	// The missing keys that have a generator are generated and written to vault
+	s.bootstrap(ctx, secrets, clientToken, pool, limiter)
+
+	// The values holding transit ciphertext are decrypted once everything is fetched
+	s.decryptSecrets(ctx, secrets, clientToken, pool, limiter)
+
+	// Then write every copy of the secrets that have all of its paths fetched
+	// in fail fast mode nothing is written once something has failed
+	if s.opts.ContinueOnError || failures(secrets) == nil {
+		var writes []worker.Job
+		var pending []*target
+		for _, secret := range secrets {
+			if secret.err != nil {
+				continue
+			}
+			if secret.upToDate {
+				for _, t := range secret.targets {
+					if t.err == nil && t.status == "" {
+						t.status = StatusUnchanged
+					}
+				}
+				continue
+			}
+			annotations := make(map[string]interface{})
+			for key, value := range secret.annotations {
+				annotations[key] = value
+			}
+			if secret.annotation != "" {
+				annotations[VersionsAnnotation] = secret.annotation
+			}
+			for _, t := range secret.targets {
+				if t.err != nil {
+					continue
+				}
+				secret, t := secret, t
+				writes = append(writes, func() error {
+					if s.opts.DryRun {
+						status, changes, err := s.diffSecret(ctx, cluster, t.namespace, secret.secretType, secret.data, annotations, objectName, secret.name)
+						if err != nil {
+							return failure(failureReason(err), fmt.Errorf("kubernetes secret cannot be compared error: %s", err))
+						}
+						t.status, t.changes = status, changes
+						return nil
+					}
+					status, err := s.create(ctx, cluster, t.namespace, secret.secretType, secret.data, annotations, objectName, secret.name)
+					if err != nil {
+						return failure(failureReason(err), fmt.Errorf("kubernetes secret cannot be created error: %s", err))
+					}
+					t.status = status
+					return nil
+				})
+				pending = append(pending, t)
+			}
+		}
+		// Without CONTINUE_ON_ERROR the first failed write stops the writes that are not started yet
+		writePool := &worker.Pool{
+			Concurrency: s.opts.Concurrency,
+			FailFast:    !s.opts.ContinueOnError,
+			Context:     ctx,
+		}
+		for i, err := range writePool.Run(writes) {
+			if err != nil && err != worker.ErrSkipped {
+				pending[i].err = err
+			}
+		}
+	}
+
+	for _, secret := range secrets {
+		if len(secret.targets) == 0 {
+			entry := SecretReport{Name: secret.name, Status: StatusSkipped}
+			if secret.err != nil {
+				entry.Status = StatusFailed
+				entry.Reason = failureReason(secret.err)
+				entry.Error = secret.err.Error()
+			} else {
+				entry.Error = "no namespace matched the selector of the secret"
+			}
+			report.add(entry)
+			continue
+		}
+		for _, t := range secret.targets {
+			entry := SecretReport{
+				Name:      secret.name,
+				Namespace: t.namespace,
+				Status:    t.status,
+				Changes:   t.changes,
+			}
+			err := t.err
+			if err == nil {
+				err = secret.err
+			}
+			if err != nil {
+				entry.Status = StatusFailed
+				entry.Reason = failureReason(err)
+				entry.Error = err.Error()
+			} else if entry.Status == "" {
+				entry.Status = StatusSkipped
+			}
+			report.add(entry)
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+
+	// A dry-run leaves no trace in kubernetes, not even the events
+	if !s.opts.DryRun {
+		s.emitEvents(ctx, cluster, report)
+	}
+	if err := writeReport(report, s.opts.ReportPath); err != nil {
+		logger.Warn(err)
+	}
+	if s.opts.DryRun {
+		printDiff(s.opts.DiffOutput, report)
+	} else {
+		logger.Infof("sync finished: %d synced, %d unchanged, %d failed, %d skipped",
+			report.Synced, report.Unchanged, report.Failed, report.Skipped)
+	}
+
+	if err := failures(secrets); err != nil {
+		return report, err
+	}
+	if report.Drifted > 0 {
+		return report, &DriftError{Drifted: report.Drifted}
+	}
+	return report, nil
+}
+
+// Read the kv v2 version of every path and tell which secrets are already written from these versions
+// A version that can't be read, ex. the policy doesn't allow the metadata, means the path is read as before
+func (s *Syncer) trackVersions(ctx context.Context, secrets []*secretJob, cluster *models.Cluster, clientToken, objectName string, pool *worker.Pool, limiter *worker.Limiter) {
+	mount, err := s.metadataPath()
+	if err != nil {
+		logger.Warnf("versions are not tracked: %s", err)
+		return
+	}
+	metadataUrl := &RequestUrl{
+		BaseUrl: s.opts.VaultAddress,
+		Path:    mount,
+	}
+
+	var lookups []worker.Job
+	for _, secret := range secrets {
+		if secret.err != nil {
+			continue
+		}
+		for i, value := range secret.spec.Paths {
+			secret, i := secret, i
+			url := metadataUrl.GetPath(value.Path)
+			namespace := s.pathNamespace(secret.spec, value)
+			lookups = append(lookups, func() error {
+				client, err := s.vaultClient()
+				if err != nil {
+					return nil
+				}
+				limiter.Wait()
+				version, err := client.GetMetadata(ctx, clientToken, url, namespace)
+				if err != nil {
+					logger.Warnf("cannot read the version of %s, the secret is read anyway: %s", url, err)
+					return nil
+				}
+				secret.versions[i] = version
+				return nil
+			})
+		}
+	}
+	pool.Run(lookups)
+
+	for _, secret := range secrets {
+		if secret.err != nil {
+			continue
+		}
+		secret.annotation = versionsAnnotation(secret.keys, secret.versions)
+		// A secret to bootstrap is fetched anyway, its keys may have been missing since the last sync
+		if secret.annotation == "" || (s.opts.Bootstrap && len(secret.spec.Generate) != 0) {
+			continue
+		}
+		// Every copy has to be at the same versions, a new namespace or a deleted copy is written again
+		upToDate := false
+		for _, t := range secret.targets {
+			if t.err != nil {
+				continue
+			}
+			if s.appliedVersions(ctx, cluster, t.namespace, objectName, secret.name) != secret.annotation {
+				upToDate = false
+				break
+			}
+			upToDate = true
+		}
+		secret.upToDate = upToDate
+	}
+}
+
+// Return the vault namespace of a path, the path wins over the secret and the secret over VAULT_NAMESPACE
+func (s *Syncer) pathNamespace(spec *models.SecretSpec, path models.PathSpec) string {
+	if path.Namespace != "" {
+		return path.Namespace
+	}
+	if spec.VaultNamespace != "" {
+		return spec.VaultNamespace
+	}
+	return s.opts.VaultNamespace
+}
+
+// namespaceResolver turns the namespace fields of a spec into the list of namespaces to write to
+type namespaceResolver struct {
+	client  Kubernetes
+	cluster *models.Cluster
+	// Patterns of the namespaces that may be written to besides the default one
+	allowed []string
+	// Namespaces matched by each label selector, so each selector is only listed once
+	selectors map[string][]string
+}
+
+// Return a target per namespace of the spec, the default namespace is used when the spec has none
+// A namespace that is not allowed gets a failed target so it shows up in the report
+func (r *namespaceResolver) targets(ctx context.Context, spec *models.SecretSpec) ([]*target, error) {
+	if !spec.HasTargets() {
+		return []*target{{namespace: r.cluster.Namespace}}, nil
+	}
+
+	var explicit []string
+	if spec.Namespace != "" {
+		explicit = append(explicit, spec.Namespace)
+	}
+	explicit = append(explicit, spec.Namespaces...)
+
+	seen := make(map[string]bool)
+	var ret []*target
+	for _, ns := range explicit {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		t := &target{namespace: ns}
+		if !r.isAllowed(ns) {
+			t.err = failure(ReasonNamespaceNotAllowed,
+				fmt.Errorf("namespace %s is not in ALLOWED_NAMESPACES", ns))
+		}
+		ret = append(ret, t)
+	}
+
+	if spec.NamespaceSelector != "" {
+		matched, ok := r.selectors[spec.NamespaceSelector]
+		if !ok {
+			var err error
+			matched, err = r.client.ListNamespaces(ctx, r.cluster, spec.NamespaceSelector)
+			if err != nil {
+				return ret, failure(ReasonApplyFailed,
+					fmt.Errorf("cannot list namespaces of selector %s: %s", spec.NamespaceSelector, err))
+			}
+			r.selectors[spec.NamespaceSelector] = matched
+		}
+		for _, ns := range matched {
+			if seen[ns] {
+				continue
+			}
+			seen[ns] = true
+			// A selector is expected to be broad, namespaces outside of the allow-list are left alone
+			if !r.isAllowed(ns) {
+				logger.Warnf("namespace %s matches selector %s but is not in ALLOWED_NAMESPACES, skipping",
+					ns, spec.NamespaceSelector)
+				continue
+			}
+			ret = append(ret, &target{namespace: ns})
+		}
+	}
+	return ret, nil
+}
+
+// The default namespace is always allowed, any other has to match a pattern of the allow-list
+func (r *namespaceResolver) isAllowed(ns string) bool {
+	if ns == r.cluster.Namespace {
+		return true
+	}
+	for _, pattern := range r.allowed {
+		if ok, _ := path.Match(pattern, ns); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitList splits a comma separated list, of the env or a flag, and drops the empty items
+func SplitList(s string) []string {
+	var ret []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// SyncError collects the error of every secret that failed to sync
+type SyncError struct {
+	// Errors keyed by the namespace and name of the kubernetes secret
+	Errors map[string]error
+}
+
+// Error lists the failed secrets in a stable order
+func (e *SyncError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, e.Errors[name]))
+	}
+	return fmt.Sprintf("%d secret(s) failed to sync: %s", len(names), strings.Join(msgs, "; "))
+}
+
+// Return a SyncError if one of the secrets has failed, nil otherwise
+func failures(secrets []*secretJob) *SyncError {
+	errs := make(map[string]error)
+	for _, secret := range secrets {
+		if secret.err != nil && len(secret.targets) == 0 {
+			errs[secret.name] = secret.err
+		}
+		for _, t := range secret.targets {
+			err := t.err
+			if err == nil {
+				err = secret.err
+			}
+			if err != nil {
+				errs[fmt.Sprintf("%s/%s", t.namespace, secret.name)] = err
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &SyncError{Errors: errs}
+}
+
+// Post an event on every secret of the report so the outcome is visible with kubectl describe
+// When running as a job the events are linked to the pod, which also gets a summary event
+// Failing to post an event is logged but never fails the sync
+func (s *Syncer) emitEvents(ctx context.Context, cluster *models.Cluster, report *Report) {
+	client := s.kubernetesClient()
+	pod := podReference(cluster.Namespace)
+
+	post := func(regarding ObjectReference, related *ObjectReference, eventType, reason, note string) {
+		payload, err := event(regarding, related, eventType, reason, note)
+		if err != nil {
+			logger.Warnf("cannot construct event for %s %s: %s", regarding.Kind, regarding.Name, err)
+			return
+		}
+		if err := client.CreateEvent(ctx, cluster, regarding.Namespace, payload); err != nil {
+			logger.Warnf("cannot post event for %s %s: %s", regarding.Kind, regarding.Name, err)
+		}
+	}
+
+	for _, secret := range report.Secrets {
+		var eventType, reason, note string
+		switch secret.Status {
+		case StatusSynced:
+			eventType, reason, note = EventNormal, ReasonSynced, "Secret has been synced from vault"
+		case StatusUnchanged:
+			eventType, reason, note = EventNormal, ReasonUnchanged, "Secret is already up to date with vault"
+		case StatusFailed:
+			eventType, reason, note = EventWarning, secret.Reason, secret.Error
+		default:
+			continue
+		}
+		// Nothing to attach the event to when the namespaces of the secret could not be resolved
+		// and a namespace outside of the allow-list must not be touched at all
+		if secret.Namespace == "" || secret.Reason == ReasonNamespaceNotAllowed {
+			continue
+		}
+		regarding := ObjectReference{
+			ApiVersion: "v1",
+			Kind:       "Secret",
+			Name:       secret.Name,
+			Namespace:  secret.Namespace,
+		}
+		post(regarding, pod, eventType, reason, note)
+	}
+
+	if pod != nil {
+		note := fmt.Sprintf("%d synced, %d unchanged, %d failed, %d skipped",
+			report.Synced, report.Unchanged, report.Failed, report.Skipped)
+		if report.Failed > 0 {
+			post(*pod, nil, EventWarning, ReasonSyncFailed, note)
+		} else {
+			post(*pod, nil, EventNormal, ReasonSyncCompleted, note)
+		}
+	}
+}
+
+// Handler to create the object
+// ATLS-627 creating multiple object
+// Returns StatusUnchanged when the secret in kubernetes already holds the same data
+func (s *Syncer) create(ctx context.Context, cluster *models.Cluster, ns, secretType string, m, annotations map[string]interface{}, objectName, secretObjectName string) (string, error) {
+	client := s.kubernetesClient()
+
+	if len(m) == 0 {
+		return StatusUnchanged, nil
+	}
+	// Depending on the status of the live object, the api call to create the object will switch between POST and PUT method
+	status, live, object, err := s.render(ctx, cluster, ns, secretType, m, annotations, objectName, secretObjectName)
+	if err != nil {
+		return "", err
+	}
+	// Skip the write when the object in kubernetes is already the same
+	if status == http.StatusOK && sameObject(live, object) {
+		return StatusUnchanged, nil
+	}
+	// Create the object to kubernetes api
+	// Object would be created if it's not present or updated if exist, the status variable will define how the object will be created
+	resp, err := client.Create(ctx, cluster, ns, objectName, secretObjectName, status, object)
+	if err != nil {
+		return "", fmt.Errorf("encountered error while creating the kubernetes secret object: %s", err)
+	}
+	// Handle the resp coming from kubernetes api
+	// loop into the struct and check if the "code" key is present in the struct
+	// pretty much not a good way to handle it but kubernetes returns a nested data structure
+	for key, value := range resp {
+		if key == "code" {
+			err := fmt.Errorf("error creating secret with repond code: %v\nErrorMessage: %v", value, resp["message"])
+			// 409 means the object was changed or created by someone else in between
+			if code, ok := value.(float64); ok && int(code) == http.StatusConflict {
+				return "", failure(ReasonApplyConflict, err)
+			}
+			return "", failure(ReasonApplyFailed, err)
+		}
+	}
+	return StatusSynced, nil
+}
+
+// Return the status and the live object in kubernetes together with the manifest of the secret that replaces it
+func (s *Syncer) render(ctx context.Context, cluster *models.Cluster, ns, secretType string, m, annotations map[string]interface{}, objectName, secretObjectName string) (int, map[string]interface{}, []byte, error) {
+	client := s.kubernetesClient()
+
+	// This call the api that checks the object in kubernetes api
+	status, live, err := client.GetSecret(ctx, cluster, ns, objectName, secretObjectName)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("encountered error while verifying secret object in kubernetes: %s", err)
+	}
+	data := utils.EncodeValue(m)
+	// The values kept by a rotation stay in the secret until the end of their grace period
+	if status == http.StatusOK {
+		data, annotations = withPrevious(live, data, annotations, time.Now())
+	}
+	// We get that secrets payload and feed it to Object() function and return the json formatted secret object manifest for kubernetes api
+	manifest, err := object(secretObjectName, ns, secretType, data, annotations)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("encountered error while constructing kubernetes object: %s", err)
+	}
+	return status, live, manifest, nil
+}
+
+// Compare the live object with the manifest we are about to send
+// only the fields we manage are compared, kubernetes adds plenty of its own
+func sameObject(live map[string]interface{}, manifest []byte) bool {
+	var desired map[string]interface{}
+	if err := json.Unmarshal(manifest, &desired); err != nil {
+		return false
+	}
+	if live["type"] != desired["type"] {
+		return false
+	}
+	liveData, _ := live["data"].(map[string]interface{})
+	desiredData, _ := desired["data"].(map[string]interface{})
+	if len(liveData) != len(desiredData) {
+		return false
+	}
+	for key, value := range desiredData {
+		if liveData[key] != value {
+			return false
+		}
+	}
+	liveMeta, _ := live["metadata"].(map[string]interface{})
+	desiredMeta, _ := desired["metadata"].(map[string]interface{})
+	// Labels and annotations added by someone else are left alone
+	for _, field := range []string{"labels", "annotations"} {
+		liveValues, _ := liveMeta[field].(map[string]interface{})
+		desiredValues, _ := desiredMeta[field].(map[string]interface{})
+		for key, value := range desiredValues {
+			if liveValues[key] != value {
+				return false
+			}
+		}
+	}
+	return true
+}
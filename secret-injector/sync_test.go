@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/apis"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func TestSecretSpec_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]*models.SecretSpec
+		wantErr bool
+	}{
+		{
+			name: "legacy-list",
+			data: `{"app-sit-secret": ["app/db", "app/api"]}`,
+			want: map[string]*models.SecretSpec{
+				"app-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}, {Path: "app/api"}}},
+			},
+		},
+		{
+			name: "spec",
+			data: `{"registry-secret": {"paths": ["shared/registry"], "namespaces": ["sit-sre"], "namespaceSelector": "team=sre"}}`,
+			want: map[string]*models.SecretSpec{
+				"registry-secret": {
+					Paths:             []models.PathSpec{{Path: "shared/registry"}},
+					Namespaces:        []string{"sit-sre"},
+					NamespaceSelector: "team=sre",
+				},
+			},
+		},
+		{
+			name: "vault-namespaces",
+			data: `{"app-secret": {"vaultNamespace": "team-a", "paths": ["app/db", "@parent:shared/registry", {"path": "shared/ca", "namespace": "parent"}]}}`,
+			want: map[string]*models.SecretSpec{
+				"app-secret": {
+					VaultNamespace: "team-a",
+					Paths: []models.PathSpec{
+						{Path: "app/db"},
+						{Path: "shared/registry", Namespace: "parent"},
+						{Path: "shared/ca", Namespace: "parent"},
+					},
+				},
+			},
+		},
+		{
+			// Without the @ prefix, or with one that isn't a namespace, the colon is part of the path
+			name: "colon-in-path",
+			data: `{"app-secret": ["app/db:v2", "@app/db:", "@team a:db"]}`,
+			want: map[string]*models.SecretSpec{
+				"app-secret": {Paths: []models.PathSpec{{Path: "app/db:v2"}, {Path: "@app/db:"}, {Path: "@team a:db"}}},
+			},
+		},
+		{
+			name:    "invalid",
+			data:    `{"app-sit-secret": "app/db"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]*models.SecretSpec
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_namespaceResolver_targets(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelSelector") != "team=sre" {
+			t.Errorf("labelSelector is incorrect %s:", r.URL.Query().Get("labelSelector"))
+		}
+		fmt.Fprintln(w, `{"items": [{"metadata": {"name": "sit-sre"}}, {"metadata": {"name": "prod-sre"}}]}`)
+	}))
+	defer server.Close()
+
+	resolver := &namespaceResolver{
+		client: &apis.Client{},
+		cluster: &models.Cluster{
+			Server:    server.URL,
+			Insecure:  true,
+			Namespace: "ops-sre",
+		},
+		allowed:   []string{"sit-*", "uat-sre"},
+		selectors: make(map[string][]string),
+	}
+
+	type result struct {
+		namespace string
+		failed    bool
+	}
+	tests := []struct {
+		name string
+		spec *models.SecretSpec
+		want []result
+	}{
+		{
+			name: "default-namespace",
+			spec: &models.SecretSpec{},
+			want: []result{{namespace: "ops-sre"}},
+		},
+		{
+			name: "explicit-namespaces",
+			spec: &models.SecretSpec{Namespace: "uat-sre", Namespaces: []string{"ops-sre", "prod-sre", "uat-sre"}},
+			want: []result{{namespace: "uat-sre"}, {namespace: "ops-sre"}, {namespace: "prod-sre", failed: true}},
+		},
+		{
+			name: "selector",
+			spec: &models.SecretSpec{NamespaceSelector: "team=sre"},
+			want: []result{{namespace: "sit-sre"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := resolver.targets(context.Background(), tt.spec)
+			if err != nil {
+				t.Fatalf("targets() error = %v", err)
+			}
+			got := make([]result, 0, len(targets))
+			for _, target := range targets {
+				got = append(got, result{namespace: target.namespace, failed: target.err != nil})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_syncSecrets_failures(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The current version of team-b was deleted
+		if r.URL.Path == "/v1/secret/data/team-b/db" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"data": {"data": null, "metadata": {"deletion_time": "2026-01-01T00:00:00Z", "destroyed": false, "version": 2}}}`)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/team-c/db" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, `{"errors": []}`)
+			return
+		}
+		fmt.Fprintln(w, `{"data": {"data": {"password": "team-c"}}}`)
+	}))
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+
+	vars := map[string]*models.SecretSpec{
+		"team-a-secret": {Paths: []models.PathSpec{{Path: "team-a/db"}}},
+		"team-b-secret": {Paths: []models.PathSpec{{Path: "team-b/db"}}},
+		"team-c-secret": {Paths: []models.PathSpec{{Path: "team-c/db"}}},
+	}
+	tests := []struct {
+		name            string
+		continueOnError bool
+		want            []string
+		wantWrites      int
+	}{
+		{
+			// Every failed path is reported, only the writes are stopped
+			name: "fail-fast",
+			want: []string{"team-a-secret failed", "team-b-secret failed", "team-c-secret skipped"},
+		},
+		{
+			name:            "continue-on-error",
+			continueOnError: true,
+			want:            []string{"team-a-secret failed", "team-b-secret failed", "team-c-secret synced"},
+			wantWrites:      1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := kube.options(vault.URL)
+			// One worker, the failure of team-a happens before the other paths are read
+			opts.Concurrency, opts.ContinueOnError = 1, tt.continueOnError
+			kube.reset()
+
+			report, err := NewSyncer(opts).syncSecrets(context.Background(), vars, "token", nil, false)
+			if _, ok := err.(*SyncError); !ok {
+				t.Errorf("syncSecrets() error = %v, want a SyncError", err)
+			}
+			var got []string
+			for _, secret := range report.Secrets {
+				got = append(got, secret.Name+" "+secret.Status)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("syncSecrets() secrets = %v, want %v", got, tt.want)
+			}
+			if writes := kube.writes(); writes != tt.wantWrites {
+				t.Errorf("syncSecrets() writes = %v, want %v", writes, tt.wantWrites)
+			}
+		})
+	}
+}
+
+// fakeKubernetes stores the secrets written to it, the kubeconfig of the test points to it
+type fakeKubernetes struct {
+	*httptest.Server
+	// Kubeconfig pointing to the fake api
+	kubeconfig string
+	mu         sync.Mutex
+	objects    map[string]map[string]interface{}
+	written    int
+	// Paths of the workloads that were patched to restart them
+	restarted []string
+}
+
+// Start a fake kubernetes api and point Kube to it as well, the namespace of the context is sit-sre
+func newFakeKubernetes(t *testing.T) *fakeKubernetes {
+	f := &fakeKubernetes{objects: make(map[string]map[string]interface{})}
+	f.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/apis/events.k8s.io/"):
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintln(w, `{}`)
+		case r.Method == http.MethodPatch:
+			f.restarted = append(f.restarted, r.URL.Path)
+			fmt.Fprintln(w, `{}`)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/secret"):
+			// Only the key=value selectors are supported
+			selector := strings.SplitN(r.URL.Query().Get("labelSelector"), "=", 2)
+			var items []map[string]interface{}
+			for path, object := range f.objects {
+				labels, _ := object["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+				if strings.HasPrefix(path, r.URL.Path+"/") && len(selector) == 2 && labels[selector[0]] == selector[1] {
+					items = append(items, object)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		case r.Method == http.MethodGet:
+			object, ok := f.objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintln(w, `{}`)
+				return
+			}
+			json.NewEncoder(w).Encode(object)
+		default:
+			var object map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&object)
+			meta := object["metadata"].(map[string]interface{})
+			f.objects[fmt.Sprintf("/api/v1/namespaces/%s/secret/%s", meta["namespace"], meta["name"])] = object
+			f.written++
+			json.NewEncoder(w).Encode(object)
+		}
+	}))
+
+	f.kubeconfig = filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(f.kubeconfig, []byte(fmt.Sprintf(`
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+contexts:
+- name: test
+  context:
+    cluster: test
+    namespace: sit-sre
+`, f.URL)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kube := Kube
+	Kube = KubeOptions{Kubeconfig: f.kubeconfig}
+	t.Cleanup(func() { Kube = kube })
+	return f
+}
+
+// Return the default options of a Syncer writing to the fake api and reading vault at address, under secret/data
+func (f *fakeKubernetes) options(vaultAddress string) Options {
+	opts := DefaultOptions()
+	opts.VaultAddress, opts.SecretPath = vaultAddress, "secret/data"
+	opts.Kube = KubeOptions{Kubeconfig: f.kubeconfig}
+	return opts
+}
+
+// Return a secret written to the fake api, nil if there's none
+func (f *fakeKubernetes) object(ns, name string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[fmt.Sprintf("/api/v1/namespaces/%s/secret/%s", ns, name)]
+}
+
+// Return the number of writes since the last reset
+func (f *fakeKubernetes) writes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written
+}
+
+func (f *fakeKubernetes) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.written = 0
+}
+
+// Add a secret to the fake api as if someone had created it
+func (f *fakeKubernetes) add(ns, name string, labels map[string]interface{}, data map[string]interface{}) {
+	encoded := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		encoded[key] = base64.StdEncoding.EncodeToString([]byte(value.(string)))
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[fmt.Sprintf("/api/v1/namespaces/%s/secret/%s", ns, name)] = map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": ns, "labels": labels},
+		"type":     "Opaque",
+		"data":     encoded,
+	}
+}
+
+// Delete a secret from the fake api
+func (f *fakeKubernetes) remove(ns, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, fmt.Sprintf("/api/v1/namespaces/%s/secret/%s", ns, name))
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/apis"
+	"github.com/trx35479/vault-gopher/secret-injector/auth"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// Options tells a Syncer what to sync and how, each field is the counterpart of an env variable of the app
+// Start from DefaultOptions, or EnvOptions to take the env and the flags like the command line does
+type Options struct {
+	// Vault the secrets are read from and the vault namespace of the secrets (VAULT_ADDR, VAULT_NAMESPACE)
+	VaultAddress   string
+	VaultNamespace string
+
+	// Login, see the auth package (VAULT_AUTH_METHOD, VAULT_AUTH_PATH, VAULT_AUTH_NAMESPACE, APPROLE_NAME...)
+	AuthMethod          string
+	AuthPath            string
+	AuthNamespace       string
+	Role                string
+	Token               string
+	TokenFile           string
+	SecretIdWrapped     bool
+	WrappedCreationPath string
+	JwtPath             string
+	JwtRole             string
+	CertRole            string
+
+	// TLS of the vault connection (VAULT_CACERT, VAULT_CLIENT_CERT, VAULT_CLIENT_KEY, VAULT_SKIP_VERIFY)
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	SkipVerify bool
+
+	// Reads from performance standbys (VAULT_CONSISTENCY, VAULT_INDEX, VAULT_CONSISTENCY_RETRIES)
+	Consistency        string
+	Index              string
+	ConsistencyRetries int
+
+	// Mounts of the secrets engines (VAULT_SECRET_PATH, VAULT_METADATA_PATH, VAULT_TRANSIT_PATH...)
+	SecretPath   string
+	MetadataPath string
+	TransitPath  string
+	SSHPath      string
+	AWSPath      string
+
+	// Kubernetes connection, resource the secrets are written as and the other namespaces they may be written to
+	Kube              KubeOptions
+	ObjectName        string
+	AllowedNamespaces []string
+
+	// Secrets to sync, the parsed SECRET_OBJECT
+	Secrets map[string]*models.SecretSpec
+
+	// Sync (SYNC_CONCURRENCY, VAULT_RATE_LIMIT, CONTINUE_ON_ERROR, SYNC_REPORT, BOOTSTRAP_SECRETS...)
+	Concurrency     int
+	RateLimit       float64
+	ContinueOnError bool
+	ReportPath      string
+	Bootstrap       bool
+	TrackVersions   bool
+	// How long a sync may take, the login included, 0 lets it run until it's done (SYNC_TIMEOUT)
+	Timeout time.Duration
+	// Print how the secrets differ to DiffOutput instead of writing them, it is not read from the env
+	// and is only set by the caller, ex. the sync command with --dry-run
+	DryRun     bool
+	DiffOutput io.Writer
+
+	// Clients the sync goes through, the apis clients built from the options above are used when they are nil
+	Vault      Vault
+	Kubernetes Kubernetes
+	// Auth logs in instead of the auth method of the options above, the health of vault is not checked then
+	// Its token is revoked after the sync, unless it's the token method of the auth package
+	Auth auth.AuthMethod
+}
+
+// The defaults are the ones of the env variables as well
+var defaults = DefaultOptions()
+
+// DefaultOptions returns the options of the app when nothing is set in the env
+func DefaultOptions() Options {
+	return Options{
+		JwtPath:            "/var/run/secrets/vault/token",
+		Consistency:        "none",
+		ConsistencyRetries: 5,
+		TransitPath:        "transit",
+		SSHPath:            "ssh",
+		AWSPath:            "aws",
+		ObjectName:         "secret",
+		Concurrency:        4,
+		RateLimit:          10,
+		DiffOutput:         os.Stdout,
+	}
+}
+
+// EnvOptions returns the options of the env and of the flags registered with Flags
+func EnvOptions() (Options, error) {
+	vars, err := secretObject()
+	if err != nil {
+		return Options{}, err
+	}
+	opts := envOptions()
+	opts.Secrets = vars
+	return opts, nil
+}
+
+// Syncer syncs the secrets of its options from vault to kubernetes
+// Every setting is read from the options of the Syncer, the Syncers of a process can run at the same time
+type Syncer struct {
+	opts Options
+
+	// TLS material of the vault connection, read once and shared by every client of the Syncer
+	tlsOnce    sync.Once
+	vaultCA    []byte
+	vaultCerts []tls.Certificate
+	tlsError   error
+
+	// Consistency of the reads, shared so the index of the login is used by every worker
+	consistencyOnce  sync.Once
+	consistency      *apis.Consistency
+	consistencyError error
+}
+
+// NewSyncer returns a Syncer of the options
+func NewSyncer(opts Options) *Syncer {
+	if opts.DiffOutput == nil {
+		opts.DiffOutput = os.Stdout
+	}
+	return &Syncer{opts: opts}
+}
+
+// Sync logs in to vault, syncs every secret once and revokes the token
+// The report is returned with a SyncError when some of the secrets failed, and a DriftError in dry-run
+// When the context is done or the timeout is over, the error of the context is returned with what was synced so far
+func (s *Syncer) Sync(ctx context.Context) (Report, error) {
+	ctx, cancel := runContext(ctx, s.opts.Timeout)
+	defer cancel()
+
+	// The token auth method lends us its token, a login given to the syncer is asked for its name so
+	// a token method of the auth package isn't revoked either. Any other login is one of ours
+	method, name := s.opts.Auth, ""
+	if method == nil {
+		var err error
+		if method, err = s.connect(ctx); err != nil {
+			return Report{}, err
+		}
+		name = s.authMethodName()
+	} else if named, ok := method.(auth.Named); ok {
+		name = named.Name()
+	}
+	token, err := method.Login(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	defer s.revokeToken(name, token.ClientToken, s.opts.Secrets)
+
+	report, err := s.syncSecrets(ctx, s.opts.Secrets, token.ClientToken, nil, s.opts.TrackVersions)
+	if ctx.Err() != nil {
+		err = fmt.Errorf("sync was interrupted: %w", ctx.Err())
+	}
+	if report == nil {
+		return Report{}, err
+	}
+	return *report, err
+}
+
+// Return the options of the env and the flags, without the secrets
+func envOptions() Options {
+	return Options{
+		VaultAddress:        vaultAddress,
+		VaultNamespace:      vaultNamespace,
+		AuthMethod:          vaultAuthMethod,
+		AuthPath:            vaultAuthPath,
+		AuthNamespace:       vaultAuthNamespace,
+		Role:                appRoleName,
+		Token:               vaultToken,
+		TokenFile:           vaultTokenFile,
+		SecretIdWrapped:     appRoleSecretIdWrapped,
+		WrappedCreationPath: appRoleWrappedCreationPath,
+		JwtPath:             vaultJwtPath,
+		JwtRole:             vaultJwtRole,
+		CertRole:            vaultCertRole,
+		CACert:              vaultCACert,
+		ClientCert:          vaultClientCert,
+		ClientKey:           vaultClientKey,
+		SkipVerify:          vaultSkipVerify,
+		Consistency:         vaultConsistency,
+		Index:               vaultIndex,
+		ConsistencyRetries:  vaultConsistencyRetries,
+		SecretPath:          vaultSecretPath,
+		MetadataPath:        vaultMetadataPath,
+		TransitPath:         vaultTransitPath,
+		SSHPath:             vaultSSHPath,
+		AWSPath:             vaultAWSPath,
+		Kube:                Kube,
+		ObjectName:          ObjectName,
+		AllowedNamespaces:   SplitList(allowedNamespaces),
+		Concurrency:         syncConcurrency,
+		RateLimit:           vaultRateLimit,
+		ContinueOnError:     continueOnError,
+		ReportPath:          syncReport,
+		Bootstrap:           bootstrapSecrets,
+		TrackVersions:       vaultTrackVersions,
+		Timeout:             SyncTimeout,
+		DiffOutput:          defaults.DiffOutput,
+	}
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/auth"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// Vault kept in memory, the methods the test doesn't need are left to the embedded nil interface
+type memoryVault struct {
+	Vault
+	mu      sync.Mutex
+	data    map[string]map[string]interface{}
+	revoked []string
+}
+
+func (v *memoryVault) GetData(ctx context.Context, token, url, namespace string) (map[string]interface{}, error) {
+	for path, data := range v.data {
+		if strings.HasSuffix(url, "/v1/"+path) {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found", url)
+}
+
+func (v *memoryVault) RevokeToken(ctx context.Context, vaultAddress, path, token, namespace string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.revoked = append(v.revoked, token)
+	return true, nil
+}
+
+// Kubernetes kept in memory, secrets are keyed by namespace/name
+type memoryKubernetes struct {
+	Kubernetes
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+}
+
+func (k *memoryKubernetes) GetSecret(ctx context.Context, cluster *models.Cluster, ns, objectName, secretName string) (int, map[string]interface{}, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if secret, ok := k.secrets[ns+"/"+secretName]; ok {
+		return http.StatusOK, secret, nil
+	}
+	return http.StatusNotFound, nil, nil
+}
+
+func (k *memoryKubernetes) Create(ctx context.Context, cluster *models.Cluster, ns, objectName, secretName string, status int, payload []byte) (map[string]interface{}, error) {
+	var secret map[string]interface{}
+	if err := json.Unmarshal(payload, &secret); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.secrets[ns+"/"+secretName] = secret
+	return secret, nil
+}
+
+func (k *memoryKubernetes) CreateEvent(ctx context.Context, cluster *models.Cluster, ns string, payload []byte) error {
+	return nil
+}
+
+type staticToken string
+
+func (t staticToken) Login(ctx context.Context) (auth.Token, error) {
+	return auth.Token{ClientToken: string(t)}, nil
+}
+
+func TestSyncer_Sync(t *testing.T) {
+	vault := &memoryVault{data: map[string]map[string]interface{}{
+		"sit/data/app/db": {"password": "sit"},
+		"uat/data/app/db": {"password": "uat"},
+	}}
+	kube := &memoryKubernetes{secrets: make(map[string]map[string]interface{})}
+
+	// Two syncers with different settings in the same process, running at the same time
+	var wg sync.WaitGroup
+	for _, env := range []string{"sit", "uat"} {
+		opts := DefaultOptions()
+		opts.VaultAddress = "https://vault:8200"
+		opts.SecretPath = env + "/data"
+		opts.Kube.Namespace = env + "-sre"
+		opts.Secrets = map[string]*models.SecretSpec{"app-" + env + "-secret": {Paths: []models.PathSpec{{Path: "app/db"}}}}
+		opts.Vault, opts.Kubernetes, opts.Auth = vault, kube, staticToken("token")
+
+		wg.Add(1)
+		go func(env string, syncer *Syncer) {
+			defer wg.Done()
+			report, err := syncer.Sync(context.Background())
+			if err != nil {
+				t.Errorf("Sync() %s error = %v", env, err)
+				return
+			}
+			if report.Synced != 1 {
+				t.Errorf("Sync() %s synced = %d, want 1", env, report.Synced)
+			}
+		}(env, NewSyncer(opts))
+	}
+	wg.Wait()
+
+	for _, env := range []string{"sit", "uat"} {
+		secret := kube.secrets[env+"-sre/app-"+env+"-secret"]
+		data, _ := secret["data"].(map[string]interface{})
+		value, _ := data["password"].(string)
+		if decoded, _ := base64.StdEncoding.DecodeString(value); string(decoded) != env {
+			t.Errorf("Sync() password = %s, want %s", decoded, env)
+		}
+	}
+	if !reflect.DeepEqual(vault.revoked, []string{"token", "token"}) {
+		t.Errorf("Sync() revoked = %v, want the token of both syncs", vault.revoked)
+	}
+}
+
+func TestSyncer_Sync_cancelled(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets map[string]*models.SecretSpec
+		revoked []string
+	}{
+		{
+			name:    "revoked",
+			secrets: map[string]*models.SecretSpec{"app-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}}}},
+			revoked: []string{"token"},
+		},
+		{
+			// The token holds the leases of the aws credentials, revoking it would revoke them too
+			name:    "aws",
+			secrets: map[string]*models.SecretSpec{"aws-sit-secret": {AWS: &models.AWSSpec{Role: "deploy"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := &memoryVault{}
+			opts := DefaultOptions()
+			opts.Kube.Namespace = "sit-sre"
+			opts.Secrets = tt.secrets
+			opts.Vault, opts.Kubernetes, opts.Auth = vault, &memoryKubernetes{}, staticToken("token")
+
+			// Cancelled between the login and the sync, ex. by SIGTERM
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := NewSyncer(opts).Sync(ctx)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Sync() error = %v, want %v", err, context.Canceled)
+			}
+			if !reflect.DeepEqual(vault.revoked, tt.revoked) {
+				t.Errorf("Sync() revoked = %v, want %v", vault.revoked, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestSyncer_Sync_lentToken(t *testing.T) {
+	// Vault of the token auth method, the token is looked up before the sync
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/"+VaultLookupSelfPath {
+			t.Errorf("Unexpected request %s", r.URL.Path)
+		}
+		fmt.Fprintln(w, `{"data": {"accessor": "acc", "ttl": 0}}`)
+	}))
+	defer server.Close()
+
+	lent, err := auth.New("token", &auth.Config{Address: server.URL, Token: "s.lent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		method  auth.AuthMethod
+		revoked []string
+	}{
+		{
+			// The token belongs to the caller, it must stay valid after the sync
+			name:   "token-method",
+			method: lent,
+		},
+		{
+			name:    "own-login",
+			method:  staticToken("token"),
+			revoked: []string{"token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := &memoryVault{data: map[string]map[string]interface{}{"secret/data/app/db": {"password": "sit"}}}
+			opts := DefaultOptions()
+			opts.VaultAddress, opts.SecretPath = server.URL, "secret/data"
+			opts.Kube.Namespace = "sit-sre"
+			opts.Secrets = map[string]*models.SecretSpec{"app-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}}}}
+			opts.Vault, opts.Kubernetes, opts.Auth = vault, &memoryKubernetes{secrets: make(map[string]map[string]interface{})}, tt.method
+
+			if _, err := NewSyncer(opts).Sync(context.Background()); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			if !reflect.DeepEqual(vault.revoked, tt.revoked) {
+				t.Errorf("Sync() revoked = %v, want %v", vault.revoked, tt.revoked)
+			}
+		})
+	}
+}
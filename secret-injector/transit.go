@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"github.com/trx35479/vault-gopher/secret-injector/worker"
+)
+
+// Decrypt the values of every secret that are marked with a transit key
+// The values of a secret sharing the same transit key are decrypted in a single batch
+func (s *Syncer) decryptSecrets(ctx context.Context, secrets []*secretJob, clientToken string, pool *worker.Pool, limiter *worker.Limiter) {
+	transitUrl := &RequestUrl{
+		BaseUrl: s.opts.VaultAddress,
+		Path:    s.opts.TransitPath,
+	}
+
+	var jobs []worker.Job
+	var owners []*secretJob
+	var done []*transitBatch
+	for _, secret := range secrets {
+		if secret.err != nil || secret.upToDate || len(secret.spec.Transit) == 0 {
+			continue
+		}
+		batches, err := transitBatches(secret.spec, secret.data)
+		if err != nil {
+			secret.err = failure(ReasonDecryptFailed, err)
+			continue
+		}
+
+		namespace := s.pathNamespace(secret.spec, models.PathSpec{})
+		for _, batch := range batches {
+			secret, batch := secret, batch
+			url := transitUrl.GetPath("decrypt/" + batch.transitKey)
+			jobs = append(jobs, func() error {
+				client, err := s.vaultClient()
+				if err != nil {
+					return failure(ReasonDecryptFailed, err)
+				}
+				limiter.Wait()
+				plaintexts, err := client.Decrypt(ctx, clientToken, url, namespace, batch.ciphertexts)
+				if err != nil {
+					return failure(ReasonDecryptFailed,
+						fmt.Errorf("cannot decrypt %s with transit key %s: %s", strings.Join(batch.keys, ", "), batch.transitKey, err))
+				}
+				batch.plaintexts = plaintexts
+				return nil
+			})
+			owners = append(owners, secret)
+			done = append(done, batch)
+		}
+	}
+
+	for i, err := range pool.Run(jobs) {
+		if err != nil {
+			if err != worker.ErrSkipped && owners[i].err == nil {
+				owners[i].err = err
+			}
+			continue
+		}
+		// The batches of a secret share its data, so the plaintexts are put in place once the pool is done
+		for j, key := range done[i].keys {
+			owners[i].data[key] = done[i].plaintexts[j]
+		}
+	}
+}
+
+// transitBatch is the ciphertexts of a secret decrypted by the same transit key
+type transitBatch struct {
+	transitKey  string
+	keys        []string
+	ciphertexts []string
+	plaintexts  []string
+}
+
+// Group the ciphertexts of a secret by transit key, sorted so the requests are predictable
+func transitBatches(spec *models.SecretSpec, data map[string]interface{}) ([]*transitBatch, error) {
+	keys := make([]string, 0, len(spec.Transit))
+	for key := range spec.Transit {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ret []*transitBatch
+	batches := make(map[string]*transitBatch)
+	for _, key := range keys {
+		transitKey := strings.TrimSpace(spec.Transit[key])
+		if transitKey == "" {
+			return nil, fmt.Errorf("key %s has no transit key", key)
+		}
+		value, ok := data[key]
+		if !ok {
+			return nil, fmt.Errorf("key %s marked for transit is not in the secret", key)
+		}
+		ciphertext, ok := value.(string)
+		if !ok || !strings.HasPrefix(ciphertext, "vault:") {
+			return nil, fmt.Errorf("key %s is not transit ciphertext", key)
+		}
+		batch, ok := batches[transitKey]
+		if !ok {
+			batch = &transitBatch{transitKey: transitKey}
+			batches[transitKey] = batch
+			ret = append(ret, batch)
+		}
+		batch.keys = append(batch.keys, key)
+		batch.ciphertexts = append(batch.ciphertexts, ciphertext)
+	}
+	return ret, nil
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+	"github.com/trx35479/vault-gopher/secret-injector/worker"
+)
+
+func Test_decryptSecrets(t *testing.T) {
+	requests := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			BatchInput []struct {
+				Ciphertext string `json:"ciphertext"`
+			} `json:"batch_input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests <- fmt.Sprintf("%s %s %d", r.URL.Path, r.Header.Get("X-Vault-Namespace"), len(body.BatchInput))
+
+		var results []map[string]string
+		for _, input := range body.BatchInput {
+			// The fake ciphertext is vault:v1: followed by the plaintext
+			plaintext := strings.TrimPrefix(input.Ciphertext, "vault:v1:")
+			if plaintext == "bad" {
+				results = append(results, map[string]string{"error": "cipher: message authentication failed"})
+				continue
+			}
+			results = append(results, map[string]string{"plaintext": base64.StdEncoding.EncodeToString([]byte(plaintext))})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"batch_results": results}})
+	}))
+	defer server.Close()
+
+	s := NewSyncer(Options{VaultAddress: server.URL, VaultNamespace: "team-a", TransitPath: "transit"})
+
+	tests := []struct {
+		name         string
+		spec         *models.SecretSpec
+		data         map[string]interface{}
+		want         map[string]interface{}
+		wantRequests []string
+		wantErr      bool
+	}{
+		{
+			name: "batch",
+			spec: &models.SecretSpec{Transit: map[string]string{"password": "app", "token": "app", "cert": "tls"}},
+			data: map[string]interface{}{
+				"password": "vault:v1:hunter2",
+				"token":    "vault:v1:abc",
+				"cert":     "vault:v1:pem",
+				"username": "admin",
+			},
+			want: map[string]interface{}{
+				"password": "hunter2",
+				"token":    "abc",
+				"cert":     "pem",
+				"username": "admin",
+			},
+			wantRequests: []string{"/v1/transit/decrypt/tls team-a 1", "/v1/transit/decrypt/app team-a 2"},
+		},
+		{
+			name:         "vault-namespace",
+			spec:         &models.SecretSpec{VaultNamespace: "team-b", Transit: map[string]string{"password": "app"}},
+			data:         map[string]interface{}{"password": "vault:v1:hunter2"},
+			want:         map[string]interface{}{"password": "hunter2"},
+			wantRequests: []string{"/v1/transit/decrypt/app team-b 1"},
+		},
+		{
+			name:    "not-ciphertext",
+			spec:    &models.SecretSpec{Transit: map[string]string{"password": "app"}},
+			data:    map[string]interface{}{"password": "hunter2"},
+			wantErr: true,
+		},
+		{
+			name:    "missing-key",
+			spec:    &models.SecretSpec{Transit: map[string]string{"password": "app"}},
+			data:    map[string]interface{}{},
+			wantErr: true,
+		},
+		{
+			name:         "decrypt-failed",
+			spec:         &models.SecretSpec{Transit: map[string]string{"password": "app"}},
+			data:         map[string]interface{}{"password": "vault:v1:bad"},
+			wantRequests: []string{"/v1/transit/decrypt/app team-a 1"},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &secretJob{name: "app-sit-secret", spec: tt.spec, data: tt.data}
+			s.decryptSecrets(context.Background(), []*secretJob{secret}, "token", &worker.Pool{Concurrency: 1}, nil)
+
+			if (secret.err != nil) != tt.wantErr {
+				t.Fatalf("decryptSecrets() error = %v, wantErr %v", secret.err, tt.wantErr)
+			}
+			if tt.wantErr && failureReason(secret.err) != ReasonDecryptFailed {
+				t.Errorf("decryptSecrets() reason = %v, want %v", failureReason(secret.err), ReasonDecryptFailed)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(secret.data, tt.want) {
+				t.Errorf("decryptSecrets() data = %v, want %v", secret.data, tt.want)
+			}
+			var got []string
+			for len(requests) != 0 {
+				got = append(got, <-requests)
+			}
+			if !reflect.DeepEqual(got, tt.wantRequests) {
+				t.Errorf("decryptSecrets() requests = %v, want %v", got, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+var (
+	// Name of a kubernetes secret, a dns subdomain
+	secretNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	// Name of a kubernetes namespace, a dns label
+	namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	// Value of a kubernetes label
+	labelValuePattern = regexp.MustCompile(`^(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?$`)
+)
+
+// capabilityCheck is a vault path a secret needs and the capabilities that allow it, any of them is enough
+type capabilityCheck struct {
+	secret    string
+	namespace string
+	path      string
+	anyOf     []string
+}
+
+// Validate checks SECRET_OBJECT and the permissions of the token on every path it reads, nothing is written
+// Every problem found is printed, an error is returned when there's at least one
+func Validate(ctx context.Context, out io.Writer) error {
+	opts, err := EnvOptions()
+	if err != nil {
+		return err
+	}
+	s := NewSyncer(opts)
+	vars := opts.Secrets
+	problems := validateConfig(vars, s.defaultNamespace())
+	if len(problems) == 0 {
+		ctx, cancel := runContext(ctx, opts.Timeout)
+		defer cancel()
+		// The permissions are only worth checking once the configuration makes sense
+		method, err := s.connect(ctx)
+		if err != nil {
+			return err
+		}
+		token, err := method.Login(ctx)
+		if err != nil {
+			return err
+		}
+		// Nothing is issued while validating
+		defer s.revokeToken(s.authMethodName(), token.ClientToken, nil)
+		problems, err = s.validateCapabilities(ctx, vars, token.ClientToken)
+		if err != nil {
+			return err
+		}
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(out, problem)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("%d problem(s) found in SECRET_OBJECT", len(problems))
+	}
+	fmt.Fprintf(out, "%d secret(s) are valid\n", len(vars))
+	return nil
+}
+
+// Return the namespace the secrets are written to by default, empty when there's no cluster to take it from
+// the validation is often run outside of the cluster, ex. in a pipeline
+func (s *Syncer) defaultNamespace() string {
+	cluster, err := s.kubernetesCluster()
+	if err != nil {
+		logger.Warnf("the default namespace is unknown, only the namespaces of the secrets are checked: %s", err)
+		return s.opts.Kube.Namespace
+	}
+	return cluster.Namespace
+}
+
+// Return the problems of the configuration that would only show up while syncing
+func validateConfig(vars map[string]*models.SecretSpec, namespace string) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		spec := vars[name]
+		add := func(format string, a ...interface{}) {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, fmt.Sprintf(format, a...)))
+		}
+		if spec == nil || (len(spec.Paths) == 0 && spec.Directory == nil && spec.SSH == nil && spec.AWS == nil) {
+			add("has no paths, directory, ssh or aws")
+			continue
+		}
+
+		sources := 0
+		for _, set := range []bool{len(spec.Paths) != 0, spec.Directory != nil, spec.SSH != nil, spec.AWS != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources > 1 {
+			add("only one of paths, directory, ssh or aws can be set")
+		}
+		for _, p := range spec.Paths {
+			if p.Path == "" {
+				add("has an empty path")
+			}
+		}
+		if spec.Directory != nil {
+			if strings.Trim(spec.Directory.Path, "/") == "" {
+				add("directory has no path")
+			}
+			if _, err := template.New("name").Parse(nameTemplate(spec.Directory)); err != nil {
+				add("invalid name template: %s", err)
+			}
+		}
+		if spec.SSH != nil && spec.SSH.Role == "" {
+			add("ssh has no role")
+		}
+		if spec.SSH != nil && spec.Type != "" && spec.Type != SSHAuthSecretType {
+			add("type %s can't be set on an ssh secret, it's always %s", spec.Type, SSHAuthSecretType)
+		}
+		if spec.Type == ServiceAccountTokenType {
+			add("type %s can't be synced, the token is issued by kubernetes", spec.Type)
+		}
+		if spec.AWS != nil && sources == 1 {
+			if err := validateAWS(name, spec); err != nil {
+				add("%s", err)
+			}
+		}
+		for key, transitKey := range spec.Transit {
+			if strings.TrimSpace(transitKey) == "" {
+				add("key %s has no transit key", key)
+			}
+		}
+		for key, gen := range spec.Generate {
+			if gen == nil {
+				continue
+			}
+			if gen.Path == "" && len(spec.Paths) == 0 {
+				add("key %s to generate has no vault path to be written to", key)
+			}
+			if len(gen.Charset) > 0 && len(gen.Charset) < 2 {
+				add("charset of key %s is too small", key)
+			}
+		}
+
+		// A directory entry is named after what is found in vault, the entry itself is never written
+		if spec.Directory != nil {
+			continue
+		}
+		if len(name) > 253 || !secretNamePattern.MatchString(name) {
+			add("name is not a valid kubernetes secret name")
+		}
+		var namespaces []string
+		if spec.Namespace != "" {
+			namespaces = append(namespaces, spec.Namespace)
+		}
+		namespaces = append(namespaces, spec.Namespaces...)
+		if len(namespaces) == 0 && namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+		for _, ns := range namespaces {
+			if len(ns) > 63 || !namespacePattern.MatchString(ns) {
+				add("namespace %s is not a valid kubernetes namespace", ns)
+				continue
+			}
+			if problem := validateObject(name, ns); problem != "" {
+				add("%s", problem)
+			}
+		}
+	}
+	return problems
+}
+
+// Build the object the way the sync does and check the labels taken from the name and the namespace
+func validateObject(name, ns string) string {
+	manifest, err := object(name, ns, "", nil, nil)
+	if err != nil {
+		return fmt.Sprintf("%s, it should be <app>-<env>-secret, ex. billing-sit-secret", err)
+	}
+	var secret struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(manifest, &secret); err != nil {
+		return err.Error()
+	}
+	keys := make([]string, 0, len(secret.Metadata.Labels))
+	for key := range secret.Metadata.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := secret.Metadata.Labels[key]
+		if len(value) > 63 || !labelValuePattern.MatchString(value) {
+			return fmt.Sprintf("label %s=%s taken from the name and namespace %s is not a valid label value", key, value, ns)
+		}
+	}
+	return ""
+}
+
+// Return the paths the secrets need that the token is not allowed to use
+func (s *Syncer) validateCapabilities(ctx context.Context, vars map[string]*models.SecretSpec, clientToken string) ([]string, error) {
+	var checks []capabilityCheck
+	secretPath := strings.Trim(s.opts.SecretPath, "/")
+	for name, spec := range vars {
+		namespace := s.pathNamespace(spec, models.PathSpec{})
+		for _, p := range spec.Paths {
+			checks = append(checks, capabilityCheck{name, s.pathNamespace(spec, p), secretPath + "/" + strings.Trim(p.Path, "/"), []string{"read"}})
+		}
+		if spec.Directory != nil {
+			mount, err := s.metadataPath()
+			if err != nil {
+				return nil, err
+			}
+			dir := models.PathSpec{Path: spec.Directory.Path, Namespace: spec.Directory.Namespace}
+			checks = append(checks, capabilityCheck{name, s.pathNamespace(spec, dir), strings.Trim(mount, "/") + "/" + strings.Trim(dir.Path, "/") + "/", []string{"list"}})
+		}
+		if spec.SSH != nil {
+			mount := spec.SSH.Mount
+			if mount == "" {
+				mount = s.opts.SSHPath
+			}
+			checks = append(checks, capabilityCheck{name, namespace, strings.Trim(mount, "/") + "/sign/" + spec.SSH.Role, []string{"update"}})
+			if spec.SSH.KeyPath != "" {
+				keyPath := models.ParsePath(spec.SSH.KeyPath)
+				checks = append(checks, capabilityCheck{name, s.pathNamespace(spec, keyPath), secretPath + "/" + strings.Trim(keyPath.Path, "/"), []string{"read"}})
+			}
+		}
+		if spec.AWS != nil {
+			mount := spec.AWS.Mount
+			if mount == "" {
+				mount = s.opts.AWSPath
+			}
+			endpoint := spec.AWS.Endpoint
+			if endpoint == "" {
+				endpoint = "creds"
+			}
+			checks = append(checks, capabilityCheck{name, namespace, strings.Trim(mount, "/") + "/" + endpoint + "/" + spec.AWS.Role, []string{"read", "update"}})
+		}
+		for _, transitKey := range spec.Transit {
+			checks = append(checks, capabilityCheck{name, namespace, strings.Trim(s.opts.TransitPath, "/") + "/decrypt/" + strings.TrimSpace(transitKey), []string{"update"}})
+		}
+		if s.opts.Bootstrap && len(spec.Generate) != 0 {
+			keys := make([]string, 0, len(spec.Generate))
+			for key := range spec.Generate {
+				keys = append(keys, key)
+			}
+			writes, err := bootstrapWrites(spec, keys)
+			if err != nil {
+				continue
+			}
+			for _, write := range writes {
+				checks = append(checks, capabilityCheck{name, s.pathNamespace(spec, write.path), secretPath + "/" + strings.Trim(write.path.Path, "/"), []string{"create", "update"}})
+			}
+		}
+	}
+
+	// One request per vault namespace, the paths of a request are relative to its namespace
+	byNamespace := make(map[string][]string)
+	for _, check := range checks {
+		byNamespace[check.namespace] = append(byNamespace[check.namespace], check.path)
+	}
+	client, err := s.vaultClient()
+	if err != nil {
+		return nil, err
+	}
+	capabilitiesUrl := &RequestUrl{BaseUrl: s.opts.VaultAddress, Path: "sys"}
+	capabilities := make(map[string]map[string][]string, len(byNamespace))
+	for namespace, paths := range byNamespace {
+		c, err := client.Capabilities(ctx, clientToken, capabilitiesUrl.GetPath("capabilities-self"), namespace, uniqueStrings(paths))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the capabilities of the token: %s", err)
+		}
+		capabilities[namespace] = c
+	}
+
+	var problems []string
+	for _, check := range checks {
+		if !allowed(capabilities[check.namespace][check.path], check.anyOf) {
+			path := versionKey(check.namespace, check.path)
+			problems = append(problems, fmt.Sprintf("%s: token needs %s on %s", check.secret, strings.Join(check.anyOf, " or "), path))
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+// Tell if the capabilities give one of the wanted ones, root gives them all and deny takes everything away
+func allowed(capabilities, anyOf []string) bool {
+	ok := false
+	for _, capability := range capabilities {
+		switch capability {
+		case "deny":
+			return false
+		case "root":
+			ok = true
+		}
+		for _, want := range anyOf {
+			if capability == want {
+				ok = true
+			}
+		}
+	}
+	return ok
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func Test_validateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]*models.SecretSpec
+		want []string
+	}{
+		{
+			name: "valid",
+			vars: map[string]*models.SecretSpec{
+				"app-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}}},
+				"git-sit-secret": {SSH: &models.SSHSpec{Role: "git"}, Namespaces: []string{"uat-sre"}},
+				"apps":           {Directory: &models.DirectorySpec{Path: "apps"}},
+			},
+		},
+		{
+			// object() needs a dash to find the app name
+			name: "single-word-name",
+			vars: map[string]*models.SecretSpec{"secret": {Paths: []models.PathSpec{{Path: "app/db"}}}},
+			want: []string{"secret: name of secrets does not satisfy the naming requirement, it should be <app>-<env>-secret, ex. billing-sit-secret"},
+		},
+		{
+			name: "invalid-names",
+			vars: map[string]*models.SecretSpec{
+				"App_Secret":                            {Paths: []models.PathSpec{{Path: "app/db"}}},
+				"app-secret":                            {Paths: []models.PathSpec{{Path: "app/db"}}, Namespace: "Sit"},
+				strings.Repeat("a", 64) + "-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}}},
+			},
+			want: []string{
+				"App_Secret: name is not a valid kubernetes secret name",
+				"App_Secret: name of secrets does not satisfy the naming requirement, it should be <app>-<env>-secret, ex. billing-sit-secret",
+				strings.Repeat("a", 64) + "-sit-secret: label app.kubernetes.io/name=" + strings.Repeat("a", 64) + " taken from the name and namespace sit-sre is not a valid label value",
+				"app-secret: namespace Sit is not a valid kubernetes namespace",
+			},
+		},
+		{
+			name: "invalid-specs",
+			vars: map[string]*models.SecretSpec{
+				"empty-sit-secret": {},
+				"both-sit-secret":  {Paths: []models.PathSpec{{Path: "app/db"}}, AWS: &models.AWSSpec{Role: "deploy"}},
+				"git-sit-secret":   {SSH: &models.SSHSpec{}},
+				"gen-sit-secret":   {SSH: &models.SSHSpec{Role: "git"}, Generate: map[string]*models.GeneratorSpec{"password": {}}},
+				"key-sit-secret":   {SSH: &models.SSHSpec{Role: "git"}, Type: "kubernetes.io/tls"},
+				"sa-sit-secret":    {Paths: []models.PathSpec{{Path: "app/token"}}, Type: ServiceAccountTokenType},
+			},
+			want: []string{
+				"both-sit-secret: only one of paths, directory, ssh or aws can be set",
+				"empty-sit-secret: has no paths, directory, ssh or aws",
+				"gen-sit-secret: key password to generate has no vault path to be written to",
+				"git-sit-secret: ssh has no role",
+				"key-sit-secret: type kubernetes.io/tls can't be set on an ssh secret, it's always kubernetes.io/ssh-auth",
+				"sa-sit-secret: type kubernetes.io/service-account-token can't be synced, the token is issued by kubernetes",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateConfig(tt.vars, "sit-sre"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateConfig() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateCapabilities(t *testing.T) {
+	capabilities := map[string]map[string][]string{
+		"": {
+			"secret/data/app/db":    {"read", "list"},
+			"secret/data/app/api":   {"deny"},
+			"transit/decrypt/app":   {"update"},
+			"secret/metadata/apps/": {"read"},
+		},
+		"team-a": {
+			"secret/data/app/db": {"root"},
+		},
+	}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/capabilities-self" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		var body struct {
+			Paths []string `json:"paths"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		data := make(map[string]interface{})
+		for _, path := range body.Paths {
+			c, ok := capabilities[r.Header.Get("X-Vault-Namespace")][path]
+			if !ok {
+				c = []string{"deny"}
+			}
+			data[path] = c
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer vault.Close()
+
+	opts := DefaultOptions()
+	opts.VaultAddress, opts.SecretPath = vault.URL, "secret/data"
+	s := NewSyncer(opts)
+
+	vars := map[string]*models.SecretSpec{
+		"app-sit-secret": {
+			Paths:   []models.PathSpec{{Path: "app/db"}, {Path: "app/api"}, {Path: "app/db", Namespace: "team-a"}},
+			Transit: map[string]string{"password": "app"},
+		},
+		"apps":           {Directory: &models.DirectorySpec{Path: "apps"}},
+		"git-sit-secret": {SSH: &models.SSHSpec{Role: "git"}},
+	}
+	want := []string{
+		"app-sit-secret: token needs read on secret/data/app/api",
+		"apps: token needs list on secret/metadata/apps/",
+		"git-sit-secret: token needs update on ssh/sign/git",
+	}
+	got, err := s.validateCapabilities(context.Background(), vars, "token")
+	if err != nil {
+		t.Fatalf("validateCapabilities() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validateCapabilities() got = %q, want %q", got, want)
+	}
+}
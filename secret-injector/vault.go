@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/apis"
+	"github.com/trx35479/vault-gopher/secret-injector/auth"
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// Return the vault client of the sync, the one given in the options or a new one with the tls settings of the options
+func (s *Syncer) vaultClient() (Vault, error) {
+	if s.opts.Vault != nil {
+		return s.opts.Vault, nil
+	}
+	return s.newVaultClient()
+}
+
+// Return a vault client configured with the tls settings of the options
+// apis.Client is not safe to share between goroutines, every worker asks for its own
+func (s *Syncer) newVaultClient() (*apis.Client, error) {
+	s.tlsOnce.Do(func() {
+		s.vaultCA, s.vaultCerts, s.tlsError = loadVaultTLS(s.opts.CACert, s.opts.ClientCert, s.opts.ClientKey)
+	})
+	if s.tlsError != nil {
+		return nil, s.tlsError
+	}
+	s.consistencyOnce.Do(func() {
+		s.consistency, s.consistencyError = apis.NewConsistency(s.opts.Consistency, s.opts.Index, s.opts.ConsistencyRetries, 500*time.Millisecond)
+	})
+	if s.consistencyError != nil {
+		return nil, s.consistencyError
+	}
+	client := &apis.Client{}
+	client.SetVaultTLS(s.vaultCA, s.vaultCerts, s.opts.SkipVerify)
+	client.SetConsistency(s.consistency)
+	return client, nil
+}
+
+// Read the ca certificate and the client certificate of vault from disk
+// Both are optional, the client certificate is needed by the cert auth method
+func loadVaultTLS(caCert, clientCert, clientKey string) ([]byte, []tls.Certificate, error) {
+	var ca []byte
+	var certs []tls.Certificate
+
+	if caCert != "" {
+		data, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read vault ca certificate error: %v", err)
+		}
+		ca = data
+	}
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, nil, fmt.Errorf("both VAULT_CLIENT_CERT and VAULT_CLIENT_KEY need to be set")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot load vault client certificate error: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	return ca, certs, nil
+}
+
+// Return the namespace of the auth method, the one of the secrets when it's not set
+func (s *Syncer) authNamespace() string {
+	if s.opts.AuthNamespace != "" {
+		return s.opts.AuthNamespace
+	}
+	return s.opts.VaultNamespace
+}
+
+// How long the revocation of the token may take, it's sent once the sync is done or interrupted
+const revokeTimeout = 10 * time.Second
+
+// Return the name of the auth method of the options, empty when it can't be told
+func (s *Syncer) authMethodName() string {
+	name, _ := auth.Method(s.opts.AuthMethod, s.opts.AuthPath)
+	return name
+}
+
+// Revoke the token of a login once we are done with it, so it doesn't stay valid until the end of its ttl
+// A token given with the token auth method belongs to someone else, and the aws credentials are revoked
+// together with the token that issued them, these tokens are left to expire
+func (s *Syncer) revokeToken(method, clientToken string, vars map[string]*models.SecretSpec) {
+	if clientToken == "" || method == "token" {
+		return
+	}
+	for _, spec := range vars {
+		if spec != nil && spec.AWS != nil {
+			logger.Info("the token is not revoked, it holds the leases of the aws credentials")
+			return
+		}
+	}
+	client, err := s.vaultClient()
+	if err != nil {
+		logger.Warnf("cannot revoke the token: %s", err)
+		return
+	}
+	// The context of the sync may be done already, ex. on SIGTERM
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	if _, err := client.RevokeToken(ctx, s.opts.VaultAddress, VaultRevokeAuthPath, clientToken, s.authNamespace()); err != nil {
+		logger.Warnf("cannot revoke the token: %s", err)
+	}
+}
+
+// Tell if vault doesn't accept the token anymore, ex. it was revoked or it expired
+// A vault that can't be reached doesn't say anything about the token, it's not rejected then
+func (s *Syncer) tokenRejected(ctx context.Context, clientToken string) bool {
+	client, err := s.vaultClient()
+	if err != nil {
+		return false
+	}
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimRight(s.opts.VaultAddress, "/"), VaultLookupSelfPath)
+	_, err = client.LookupSelf(ctx, clientToken, url, s.authNamespace())
+	var respErr *apis.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
+
+// Return the auth method of the options
+func (s *Syncer) authMethod() (auth.AuthMethod, error) {
+	name, err := auth.Method(s.opts.AuthMethod, s.opts.AuthPath)
+	if err != nil {
+		return nil, err
+	}
+	// The tls material is needed up front, the cert method checks for a client certificate
+	if _, err := s.newVaultClient(); err != nil {
+		return nil, err
+	}
+	return auth.New(name, &auth.Config{
+		Address:             s.opts.VaultAddress,
+		MountPath:           s.opts.AuthPath,
+		Namespace:           s.authNamespace(),
+		Role:                s.opts.Role,
+		CredentialPath:      fmt.Sprintf("%s/%s", VaultAuthenticationPath, "token"),
+		SecretIdWrapped:     s.opts.SecretIdWrapped,
+		WrappedCreationPath: s.opts.WrappedCreationPath,
+		JwtPath:             s.opts.JwtPath,
+		JwtRole:             s.opts.JwtRole,
+		CertRole:            s.opts.CertRole,
+		ClientCertificate:   len(s.vaultCerts) != 0,
+		Token:               s.opts.Token,
+		TokenFile:           s.opts.TokenFile,
+		Client:              s.newVaultClient,
+	})
+}
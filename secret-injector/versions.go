@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+// VersionsAnnotation holds the kv v2 versions the secret was written from
+// a secret whose vault paths are still at these versions is not read again
+const VersionsAnnotation = "vault-gopher.io/versions"
+
+// versionCache keeps the payloads that were read in memory in the long-running mode
+// so a change to one path of a secret doesn't read the other paths again
+// What was written is always taken from the annotation, a secret deleted by someone is written again
+type versionCache struct {
+	mu sync.Mutex
+	// Payload of each vault path at the version it was read, keyed by the vault namespace and path
+	payloads map[string]cachedPayload
+}
+
+type cachedPayload struct {
+	version models.Version
+	data    map[string]interface{}
+}
+
+func newVersionCache() *versionCache {
+	return &versionCache{payloads: make(map[string]cachedPayload)}
+}
+
+// Return the payload of the path if it was read at the same version, every method is safe on a nil cache
+func (c *versionCache) payload(key string, version *models.Version) (map[string]interface{}, bool) {
+	if c == nil || version == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.payloads[key]
+	if !ok || cached.version != *version {
+		return nil, false
+	}
+	return cached.data, true
+}
+
+func (c *versionCache) setPayload(key string, version *models.Version, data map[string]interface{}) {
+	if c == nil || version == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payloads[key] = cachedPayload{version: *version, data: data}
+}
+
+// Key of a vault path in the cache and in the annotation, the namespace is part of it
+func versionKey(namespace, path string) string {
+	if namespace == "" {
+		return path
+	}
+	return fmt.Sprintf("%s:%s", namespace, path)
+}
+
+// Return the versions annotation of a secret, empty when the version of one of its paths is unknown
+func versionsAnnotation(keys []string, versions []*models.Version) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	m := make(map[string]models.Version, len(keys))
+	for i, key := range keys {
+		if versions[i] == nil {
+			return ""
+		}
+		m[key] = *versions[i]
+	}
+	// Maps are marshalled with sorted keys so the value is stable
+	ret, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(ret)
+}
+
+// Return the versions annotation of a copy of a secret in kubernetes
+// A copy that doesn't exist yet or can't be read has no versions
+func (s *Syncer) appliedVersions(ctx context.Context, cluster *models.Cluster, ns, objectName, name string) string {
+	client := s.kubernetesClient()
+	status, live, err := client.GetSecret(ctx, cluster, ns, objectName, name)
+	if err != nil || status != 200 {
+		return ""
+	}
+	// A copy holding previous values past their grace period is written again to drop them
+	if r := liveRotation(live); r != nil && !time.Now().Before(r.Until) {
+		return ""
+	}
+	meta, _ := live["metadata"].(map[string]interface{})
+	annotations, _ := meta["annotations"].(map[string]interface{})
+	versions, _ := annotations[VersionsAnnotation].(string)
+	return versions
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trx35479/vault-gopher/secret-injector/models"
+)
+
+func Test_versionsAnnotation(t *testing.T) {
+	v1 := &models.Version{Version: 1, UpdatedTime: "2026-01-01T00:00:00Z"}
+	v3 := &models.Version{Version: 3, UpdatedTime: "2026-02-01T00:00:00Z"}
+
+	got := versionsAnnotation([]string{"app/db", "shared:registry"}, []*models.Version{v3, v1})
+	want := `{"app/db":{"version":3,"updated_time":"2026-02-01T00:00:00Z"},"shared:registry":{"version":1,"updated_time":"2026-01-01T00:00:00Z"}}`
+	if got != want {
+		t.Errorf("versionsAnnotation() got = %v, want %v", got, want)
+	}
+	if got := versionsAnnotation([]string{"app/db", "app/api"}, []*models.Version{v1, nil}); got != "" {
+		t.Errorf("versionsAnnotation() with an unknown version got = %v, want empty", got)
+	}
+}
+
+func Test_syncSecrets_versions(t *testing.T) {
+	var mu sync.Mutex
+	versions := map[string]int{"app/db": 1, "app/api": 1}
+	reads := map[string]int{}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p := strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/"); p != r.URL.Path {
+			fmt.Fprintf(w, `{"data": {"current_version": %d, "updated_time": "2026-01-0%dT00:00:00Z"}}`, versions[p], versions[p])
+			return
+		}
+		p := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		reads[p]++
+		key := strings.Replace(p, "/", "-", -1)
+		fmt.Fprintf(w, `{"data": {"data": {"%s": "v%d"}}}`, key, versions[p])
+	}))
+	defer vault.Close()
+
+	kube := newFakeKubernetes(t)
+	defer kube.Close()
+
+	s := NewSyncer(kube.options(vault.URL))
+
+	vars := map[string]*models.SecretSpec{
+		"app-sit-secret": {Paths: []models.PathSpec{{Path: "app/db"}, {Path: "app/api"}}},
+	}
+	cache := newVersionCache()
+	tests := []struct {
+		name       string
+		bump       string
+		cache      *versionCache
+		wantReads  map[string]int
+		wantWrites int
+		wantData   map[string]interface{}
+	}{
+		{
+			name:       "first-sync",
+			cache:      cache,
+			wantReads:  map[string]int{"app/db": 1, "app/api": 1},
+			wantWrites: 1,
+			wantData:   map[string]interface{}{"app-db": "djE=", "app-api": "djE="},
+		},
+		{
+			name:       "nothing-changed",
+			cache:      cache,
+			wantReads:  map[string]int{},
+			wantWrites: 0,
+			wantData:   map[string]interface{}{"app-db": "djE=", "app-api": "djE="},
+		},
+		{
+			name:       "one-path-changed",
+			bump:       "app/api",
+			cache:      cache,
+			wantReads:  map[string]int{"app/api": 1},
+			wantWrites: 1,
+			wantData:   map[string]interface{}{"app-db": "djE=", "app-api": "djI="},
+		},
+		{
+			name:       "one-shot-changed",
+			bump:       "app/db",
+			wantReads:  map[string]int{"app/db": 1, "app/api": 1},
+			wantWrites: 1,
+			wantData:   map[string]interface{}{"app-db": "djI=", "app-api": "djI="},
+		},
+		{
+			name:       "one-shot-unchanged",
+			wantReads:  map[string]int{},
+			wantWrites: 0,
+			wantData:   map[string]interface{}{"app-db": "djI=", "app-api": "djI="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			if tt.bump != "" {
+				versions[tt.bump]++
+			}
+			reads = map[string]int{}
+			mu.Unlock()
+			kube.reset()
+
+			if _, err := s.syncSecrets(context.Background(), vars, "token", tt.cache, true); err != nil {
+				t.Fatalf("syncSecrets() error = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if fmt.Sprint(reads) != fmt.Sprint(tt.wantReads) {
+				t.Errorf("syncSecrets() reads = %v, want %v", reads, tt.wantReads)
+			}
+			if writes := kube.writes(); writes != tt.wantWrites {
+				t.Errorf("syncSecrets() writes = %v, want %v", writes, tt.wantWrites)
+			}
+			object := kube.object("sit-sre", "app-sit-secret")
+			if fmt.Sprint(object["data"]) != fmt.Sprint(tt.wantData) {
+				t.Errorf("syncSecrets() data = %v, want %v", object["data"], tt.wantData)
+			}
+			annotations := object["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+			want := fmt.Sprintf(`{"app/api":{"version":%d,"updated_time":"2026-01-0%dT00:00:00Z"},"app/db":{"version":%d,"updated_time":"2026-01-0%dT00:00:00Z"}}`,
+				versions["app/api"], versions["app/api"], versions["app/db"], versions["app/db"])
+			if annotations[VersionsAnnotation] != want {
+				t.Errorf("syncSecrets() annotation = %v, want %v", annotations[VersionsAnnotation], want)
+			}
+		})
+	}
+}
+
+func Test_tokenRejected(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Vault-Token") {
+		case "s.valid":
+			fmt.Fprintln(w, `{"data": {"accessor": "acc", "ttl": 3600}}`)
+		case "s.sealed":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, `{"errors": ["Vault is sealed"]}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, `{"errors": ["permission denied"]}`)
+		}
+	}))
+	defer vault.Close()
+
+	s := NewSyncer(Options{VaultAddress: vault.URL})
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "valid", token: "s.valid"},
+		{name: "revoked", token: "s.revoked", want: true},
+		// Vault can't tell, the token is kept
+		{name: "sealed", token: "s.sealed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.tokenRejected(context.Background(), tt.token); got != tt.want {
+				t.Errorf("tokenRejected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchTrackVersions(t *testing.T) {
+	defer func(track bool) { vaultTrackVersions = track }(vaultTrackVersions)
+
+	tests := []struct {
+		name    string
+		env     string
+		flag    bool
+		flagSet bool
+		want    bool
+	}{
+		{name: "default", want: true},
+		{name: "env", env: "false"},
+		{name: "flag", env: "true", flagSet: true},
+		{name: "flag-on", env: "false", flag: true, flagSet: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("VAULT_TRACK_VERSIONS", tt.env)
+			defer os.Unsetenv("VAULT_TRACK_VERSIONS")
+			vaultTrackVersions = tt.flag
+			if got := WatchTrackVersions(tt.flagSet); got != tt.want {
+				t.Errorf("WatchTrackVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is returned for a job that was never started because an earlier job failed
+var ErrSkipped = errors.New("job skipped after an earlier failure")
+
+// Job is a single unit of work handed over to the pool
+type Job func() error
+
+// Pool runs jobs with a bounded number of goroutines
+type Pool struct {
+	// Number of jobs that are allowed to run at the same time, anything below 1 runs the jobs one by one
+	Concurrency int
+	// Stop scheduling new jobs as soon as one of the jobs returned an error
+	FailFast bool
+	// Jobs are not started anymore once the context is done, they get the error of the context
+	Context context.Context
+}
+
+// Run executes every job and returns their errors in the same order as the jobs were given
+// Jobs that were not started because of FailFast will have ErrSkipped as their error
+func (p *Pool) Run(jobs []Job) []error {
+	errs := make([]error, len(jobs))
+
+	workers := p.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	var (
+		mu     sync.Mutex
+		failed bool
+		wg     sync.WaitGroup
+	)
+	queue := make(chan int)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				mu.Lock()
+				skip := p.FailFast && failed
+				mu.Unlock()
+				if skip {
+					errs[i] = ErrSkipped
+					continue
+				}
+				if p.Context != nil && p.Context.Err() != nil {
+					errs[i] = p.Context.Err()
+					continue
+				}
+				if err := jobs[i](); err != nil {
+					errs[i] = err
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return errs
+}
+
+// Limiter paces the requests so we don't flood the remote api
+// A nil Limiter does not limit anything
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter returns a limiter that allows rate requests per second
+// A rate of zero or below disables the limit
+func NewLimiter(rate float64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &Limiter{
+		interval: time.Duration(float64(time.Second) / rate),
+	}
+}
+
+// Wait blocks until the next request is allowed to be sent
+func (l *Limiter) Wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
@@ -0,0 +1,107 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool_Run(t *testing.T) {
+	failure := errors.New("failed")
+	tests := []struct {
+		name     string
+		failFast bool
+		jobs     []error
+		want     []error
+	}{
+		{
+			name:     "all-succeed",
+			failFast: true,
+			jobs:     []error{nil, nil, nil},
+			want:     []error{nil, nil, nil},
+		},
+		{
+			name:     "keep-going",
+			failFast: false,
+			jobs:     []error{failure, nil, failure},
+			want:     []error{failure, nil, failure},
+		},
+		{
+			name:     "fail-fast",
+			failFast: true,
+			jobs:     []error{failure, nil, nil},
+			want:     []error{failure, ErrSkipped, ErrSkipped},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Pool{
+				Concurrency: 1,
+				FailFast:    tt.failFast,
+			}
+			jobs := make([]Job, 0, len(tt.jobs))
+			for _, err := range tt.jobs {
+				err := err
+				jobs = append(jobs, func() error { return err })
+			}
+			if got := p.Run(jobs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPool_RunConcurrency(t *testing.T) {
+	var running, peak int32
+	jobs := make([]Job, 10)
+	for i := range jobs {
+		jobs[i] = func() error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		}
+	}
+	p := &Pool{Concurrency: 3}
+	p.Run(jobs)
+	if peak > 3 {
+		t.Errorf("Run() ran %d jobs at the same time, want at most 3", peak)
+	}
+}
+
+func TestPool_RunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs := []Job{
+		func() error { cancel(); return nil },
+		func() error { return nil },
+	}
+	p := &Pool{Concurrency: 1, Context: ctx}
+	if got, want := p.Run(jobs), []error{nil, context.Canceled}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Run() = %v, want %v", got, want)
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	l := NewLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.Wait()
+	}
+	// The first request is sent right away, the other four are paced 10ms apart
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Wait() allowed 5 requests in %s, want at least 40ms", elapsed)
+	}
+
+	// A nil limiter must never block
+	var none *Limiter
+	none.Wait()
+}